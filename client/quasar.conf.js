@@ -9,7 +9,7 @@ module.exports = function (ctx) {
   return {
     // app plugins (/src/plugins)
     plugins: [
-      'basicauth',
+      'auth',
       'i18n',
       'axios'
     ],
@@ -2,16 +2,15 @@ import Http from 'axios'
 import Config from 'src/config'
 
 export default {
-  async attemptLogin (credentials) {
-    try {
-      let response = await Http.get(Config('api.basic_auth'), {
-        auth: credentials,
-        withCredentials: true
-      })
-      return new Promise(resolve => resolve(response))
-    } catch (error) {
-      return new Promise((resolve, reject) => reject(error))
-    }
+  // credentials are login, password and the one-time code of two-factor authentication
+  attemptLogin (credentials) {
+    return Http.post(Config('api.login'), credentials)
+  },
+  refresh (refreshToken) {
+    return Http.post(Config('api.refresh'), { refreshToken: refreshToken }, { __isRetryRequest: true })
+  },
+  logout (refreshToken) {
+    return Http.post(Config('api.logout'), { refreshToken: refreshToken }, { __isRetryRequest: true })
   },
   addAuthorizationHeader (header) {
     Http.defaults.headers.common['Authorization'] = header
@@ -6,7 +6,10 @@
 import { Cookies, LocalStorage } from 'quasar'
 import AuthService from './auth.service'
 
-class BasicAuth {
+// refreshes of every instance share one request, a refresh token works once
+let refreshing = null
+
+class TokenAuth {
   constructor () {
     this.storages = {
       Cookies,
@@ -16,30 +19,36 @@ class BasicAuth {
   }
 
   logout () {
-    this.session.remove('basic_auth')
-    AuthService.addAuthorizationHeader('')
+    let refreshToken = this.getItem('refresh_token')
+    this.clearSession()
+    if (refreshToken) {
+      // the access token expires on its own
+      AuthService.logout(refreshToken).catch(error => {
+        console.log('Logout error: ', error)
+      })
+    }
   }
 
   guest () {
-    return !this.session.has('basic_auth')
+    return !this.session.has('refresh_token')
   }
 
   isAuthenticated () {
-    return this.session.has('basic_auth')
+    return this.session.has('refresh_token')
   }
 
-  login (username, password) {
+  login (username, password, code) {
     let self = this
     let data = {
-      username: username,
-      password: password
+      login: username,
+      password: password,
+      code: code || ''
     }
 
-    // We merge grant type and client secret stored in configuration
     return new Promise((resolve, reject) => {
       AuthService.attemptLogin(data)
         .then(response => {
-          self.storeSession(data)
+          self.storeSession(response.data.data)
           self.addAuthHeaders()
           resolve(response)
         })
@@ -50,9 +59,31 @@ class BasicAuth {
     })
   }
 
+  // refresh renews the access token, the promise is rejected once the session has ended
+  refresh () {
+    let self = this
+    if (!refreshing) {
+      refreshing = AuthService.refresh(this.getItem('refresh_token'))
+        .then(response => {
+          self.storeSession(response.data.data)
+          self.addAuthHeaders()
+          return response
+        })
+        .catch(error => {
+          self.clearSession()
+          throw error
+        })
+        .finally(() => {
+          refreshing = null
+        })
+    }
+
+    return refreshing
+  }
+
   getAuthHeader () {
-    if (this.session.has('basic_auth')) {
-      return this.getItem('basic_auth')
+    if (this.session.has('access_token')) {
+      return 'Bearer ' + this.getItem('access_token')
     }
     return null
   }
@@ -67,8 +98,17 @@ class BasicAuth {
   }
 
   storeSession (data) {
-    this.session.set('basic_auth', 'Basic ' + btoa(data.username + ':' + data.password))
+    this.session.set('access_token', data.accessToken)
+    this.session.set('refresh_token', data.refreshToken)
+    this.session.set('permissions', data.permissions)
+  }
+
+  clearSession () {
+    this.session.remove('access_token')
+    this.session.remove('refresh_token')
+    this.session.remove('permissions')
+    AuthService.addAuthorizationHeader('')
   }
 }
 
-export default BasicAuth
+export default TokenAuth
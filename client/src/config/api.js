@@ -6,5 +6,7 @@ export default {
   captcha_id: 'captcha/id',
   captcha: api_url + 'captcha/',
 
-  basic_auth: 'admin/basic-auth'
+  login: 'admin/login',
+  refresh: 'admin/refresh',
+  logout: 'admin/logout'
 }
@@ -0,0 +1,7 @@
+import TokenAuth from 'src/app/auth'
+
+export default ({ app, router, Vue }) => {
+  Vue.prototype.$auth = new TokenAuth()
+  // the session outlives page reloads
+  Vue.prototype.$auth.addAuthHeaders()
+}
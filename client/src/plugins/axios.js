@@ -13,6 +13,19 @@ export default ({ Vue }) => {
   }, err => {
     const error = err.response || err
     if (error.status === 401 && error.config && !error.config.__isRetryRequest && Router.currentRoute.path !== '/login') {
+      // the access token has expired, retry once with a refreshed one
+      const auth = Vue.prototype.$auth
+      if (auth.isAuthenticated()) {
+        return auth.refresh().then(() => {
+          error.config.__isRetryRequest = true
+          error.config.headers['Authorization'] = auth.getAuthHeader()
+          return axios(error.config)
+        }, () => {
+          Router.replace('/logout')
+          return Promise.reject(error)
+        })
+      }
+
       Router.replace('/logout')
     }
 
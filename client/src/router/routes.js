@@ -1,5 +1,5 @@
-import BasicAuth from 'src/app/auth'
-const basicAuth = new BasicAuth()
+import TokenAuth from 'src/app/auth'
+const auth = new TokenAuth()
 
 export default [
   {
@@ -23,7 +23,7 @@ export default [
   { path: '/logout',
     name: 'app.logout',
     beforeEnter (to, from, next) {
-      basicAuth.logout()
+      auth.logout()
       next('/')
     }
   },
@@ -35,7 +35,7 @@ export default [
 ]
 
 function requireAuth (to, from, next) {
-  if (!basicAuth.isAuthenticated()) {
+  if (!auth.isAuthenticated()) {
     next({
       path: '/login',
       query: { redirect: to.fullPath }
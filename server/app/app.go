@@ -1,12 +1,18 @@
 package app
 
 import (
+	"errors"
+
 	"../config"
 	"../db"
+	"../email"
+	"../encryption"
 	"../model"
 	"../router"
+	"../storage"
 
 	"github.com/kataras/iris"
+	"github.com/go-xorm/xorm"
 )
 
 func NewApp() *iris.Application {
@@ -18,18 +24,46 @@ func NewApp() *iris.Application {
 	// load templates
 	app.RegisterView(iris.HTML("./templates", ".html").Reload(!config.Config.Debug))
 
-	engine, err := db.Init()
+	engine, err := Init()
 	if err != nil {
-		app.Logger().Fatalf("db failed to initialized: %v", err)
+		app.Logger().Fatalf("%v", err)
 	}
 
 	iris.RegisterOnInterrupt(func() {
 		engine.Close()
 	})
 
-	engine.Sync2(new(model.Whitelist), new(model.Photo), new(model.WhitelistToken))
-
 	router.Routes(app)
 
 	return app
 }
+
+// Init sets up services shared by the web application and console commands.
+func Init() (*xorm.Engine, error) {
+	engine, err := db.Init()
+	if err != nil {
+		return nil, errors.New("db failed to initialized: " + err.Error())
+	}
+
+	if _, err := storage.Init(); err != nil {
+		return nil, errors.New("storage failed to initialized: " + err.Error())
+	}
+
+	if _, err := encryption.Init(); err != nil {
+		return nil, errors.New("encryption failed to initialized: " + err.Error())
+	}
+
+	if _, err := email.Init(); err != nil {
+		return nil, errors.New("mail failed to initialized: " + err.Error())
+	}
+
+	engine.Sync2(new(model.Whitelist), new(model.Photo), new(model.WhitelistToken), new(model.WhitelistStageEvent), new(model.WhitelistNote), new(model.WhitelistEmail),
+		new(model.Admin), new(model.AdminSession), new(model.AdminRecoveryCode),
+		new(model.AdminLoginAttempt), new(model.AdminLockout), new(model.RateLimitEvent))
+
+	if err := model.BootstrapAdmin(config.Config.AdminLogin, config.Config.AdminPassword); err != nil {
+		return nil, errors.New("can't create the first admin: " + err.Error())
+	}
+
+	return engine, nil
+}
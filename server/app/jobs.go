@@ -0,0 +1,87 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"../config"
+	"../email"
+	"../job"
+	"../model"
+)
+
+// StartJobs runs background jobs of the web application, an interval of 0 disables a job.
+// The returned func stops them.
+func StartJobs() (stop func()) {
+	var stops []func()
+
+	if config.Config.MailQueueIntervalSeconds > 0 {
+		stops = append(stops, job.Every(time.Duration(config.Config.MailQueueIntervalSeconds)*time.Second, "send-mail", func() error {
+			_, err := email.Deliver()
+			return err
+		}))
+	}
+
+	if config.Config.PurgeIntervalMinutes > 0 {
+		stops = append(stops, job.Every(time.Duration(config.Config.PurgeIntervalMinutes)*time.Minute, "purge-unconfirmed", func() error {
+			ids, err := model.PurgeUnconfirmed(UnconfirmedGrace(), false)
+			if len(ids) > 0 {
+				fmt.Printf("Purged unconfirmed whitelists: %v\n", ids)
+			}
+			return err
+		}))
+
+		stops = append(stops, job.Every(time.Duration(config.Config.PurgeIntervalMinutes)*time.Minute, "purge-lockouts", model.PurgeLockouts))
+
+		stops = append(stops, job.Every(time.Duration(config.Config.PurgeIntervalMinutes)*time.Minute, "purge-rate-limits", model.PurgeRateLimitEvents))
+
+		stops = append(stops, job.Every(time.Duration(config.Config.PurgeIntervalMinutes)*time.Minute, "enforce-retention", func() error {
+			retention, err := Retention()
+			if err != nil {
+				return err
+			}
+
+			ids, err := model.EnforceRetention(retention, false)
+			if len(ids) > 0 {
+				fmt.Printf("Erased whitelists after the retention period: %v\n", ids)
+			}
+			return err
+		}))
+	}
+
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// Retention parses RetentionDays.
+func Retention() (map[model.VerificationStage]time.Duration, error) {
+	retention := map[model.VerificationStage]time.Duration{}
+	for name, days := range config.Config.RetentionDays {
+		stage, ok := retentionStages[name]
+		if !ok {
+			return nil, errors.New("Unknown stage in RetentionDays: " + name)
+		}
+		if days > 0 {
+			retention[stage] = time.Duration(days) * 24 * time.Hour
+		}
+	}
+
+	return retention, nil
+}
+
+// unconfirmed applications are purged instead
+var retentionStages = map[string]model.VerificationStage{
+	"confirmed": model.STAGE_EMAIL_CONFIRMED,
+	"declined":  model.STAGE_DECLINED,
+	"question":  model.STAGE_QUESTION,
+	"accepted":  model.STAGE_ACCEPTED,
+}
+
+// UnconfirmedGrace is how long an application is kept after its confirmation link expired.
+func UnconfirmedGrace() time.Duration {
+	return time.Duration(config.Config.UnconfirmedGraceDays) * 24 * time.Hour
+}
@@ -1,14 +1,31 @@
 package main
 
 import (
+	"os"
+
 	"../app"
+	"../command"
 	"../config"
 
 	"github.com/kataras/iris"
 )
 
 func main() {
-	app.NewApp().Run(iris.Addr(config.Config.Port),
+	if len(os.Args) > 1 {
+		if err := command.Run(os.Args[1:]); err != nil {
+			println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	application := app.NewApp()
+
+	// the jobs run with the server only, tests build the app without them
+	stopJobs := app.StartJobs()
+	defer stopJobs()
+
+	application.Run(iris.Addr(config.Config.Port),
 		iris.WithoutServerError(iris.ErrServerClosed),
 		iris.WithPostMaxMemory(config.Config.MaxFileUploadSizeMb<<20))
 }
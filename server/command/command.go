@@ -0,0 +1,164 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"../app"
+	"../email"
+	"../model"
+	"../utils"
+)
+
+// Run executes a console command, e.g. "kyc rotate-keys".
+func Run(args []string) error {
+	engine, err := app.Init()
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	switch args[0] {
+	case "rotate-keys":
+		return rotateKeys()
+	case "send-mail":
+		return sendMail()
+	case "purge-unconfirmed":
+		return purgeUnconfirmed(args[1:])
+	case "enforce-retention":
+		return enforceRetention(args[1:])
+	case "create-admin":
+		return createAdmin(args[1:])
+	case "reset-admin":
+		return resetAdmin(args[1:])
+	default:
+		return errors.New("Unknown command: " + args[0])
+	}
+}
+
+// rotateKeys re-wraps data keys of files and TOTP secrets with EncryptionKey, the data is not re-encrypted.
+func rotateKeys() error {
+	count, err := model.RewrapPhotoKeys()
+	fmt.Printf("Rewrapped %v data keys\n", count)
+	if err != nil {
+		return err
+	}
+
+	count, err = model.RewrapTotpKeys()
+	fmt.Printf("Rewrapped %v TOTP keys\n", count)
+
+	return err
+}
+
+// sendMail delivers the email outbox once, for setups without the background worker.
+func sendMail() error {
+	count, err := email.Deliver()
+	fmt.Printf("Sent %v emails\n", count)
+
+	return err
+}
+
+// purgeUnconfirmed deletes abandoned applications, e.g. "kyc purge-unconfirmed -dry-run -grace-days 14".
+func purgeUnconfirmed(args []string) error {
+	flags := flag.NewFlagSet("purge-unconfirmed", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list applications without deleting them")
+	graceDays := flags.Int("grace-days", -1, "days after the confirmation link expired, UnconfirmedGraceDays by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	grace := app.UnconfirmedGrace()
+	if *graceDays >= 0 {
+		grace = time.Duration(*graceDays) * 24 * time.Hour
+	}
+
+	ids, err := model.PurgeUnconfirmed(grace, *dryRun)
+	if *dryRun {
+		fmt.Printf("Would delete %v whitelists: %v\n", len(ids), ids)
+	} else {
+		fmt.Printf("Deleted %v whitelists: %v\n", len(ids), ids)
+	}
+
+	return err
+}
+
+// enforceRetention erases applications after RetentionDays, e.g. "kyc enforce-retention -dry-run".
+func enforceRetention(args []string) error {
+	flags := flag.NewFlagSet("enforce-retention", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list applications without erasing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	retention, err := app.Retention()
+	if err != nil {
+		return err
+	}
+
+	ids, err := model.EnforceRetention(retention, *dryRun)
+	if *dryRun {
+		fmt.Printf("Would erase %v whitelists: %v\n", len(ids), ids)
+	} else {
+		fmt.Printf("Erased %v whitelists: %v\n", len(ids), ids)
+	}
+
+	return err
+}
+
+// createAdmin adds an admin with a random password, e.g. "kyc create-admin -role reviewer alice".
+func createAdmin(args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	role := flags.String("role", model.ROLE_VIEWER, "viewer, reviewer, approver or superadmin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Usage: create-admin [-role <role>] <login>")
+	}
+
+	password := utils.SecureRandomString(20)
+	if _, err := model.NewAdmin(flags.Arg(0), password, *role); err != nil {
+		return err
+	}
+	fmt.Printf("Created %s %s with password: %s\n", *role, flags.Arg(0), password)
+
+	return nil
+}
+
+// resetAdmin sets a random password and enables the admin, e.g. "kyc reset-admin -disable-totp alice".
+func resetAdmin(args []string) error {
+	flags := flag.NewFlagSet("reset-admin", flag.ContinueOnError)
+	disableTotp := flags.Bool("disable-totp", false, "turn two-factor authentication off, for a lost authenticator")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Usage: reset-admin [-disable-totp] <login>")
+	}
+
+	admin, has, err := model.GetAdminByLogin(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !has {
+		return errors.New("Unknown admin: " + flags.Arg(0))
+	}
+	if *disableTotp {
+		if err = admin.DisableTotp(); err != nil {
+			return err
+		}
+	}
+
+	password, err := admin.ResetPassword()
+	if err != nil {
+		return err
+	}
+	if err = admin.SetDisabled(false); err != nil {
+		return err
+	}
+	fmt.Printf("New password of admin %s: %s\n", admin.Login, password)
+
+	return nil
+}
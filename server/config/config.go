@@ -1,7 +1,6 @@
 package config
 
 import (
-	"../ses"
 	"path/filepath"
 	"io/ioutil"
 	"gopkg.in/yaml.v2"
@@ -10,22 +9,86 @@ import (
 // config file structure
 type config struct {
 	Debug bool `yaml:"Debug"`
-	AppKey string `yaml:"AppKey"`
+	AppKey string `yaml:"AppKey"` // signs photo URLs and admin tokens
+
+	SignedUrlTtlMinutes int `yaml:"SignedUrlTtlMinutes"`
 
 	AdminLogin string `yaml:"AdminLogin"`
 	AdminPassword string `yaml:"AdminPassword"`
 
+	AdminSessionTtlMinutes     int `yaml:"AdminSessionTtlMinutes"`     // refresh tokens, the admin logs in again after it
+	AdminAccessTokenTtlMinutes int `yaml:"AdminAccessTokenTtlMinutes"` // signed with AppKey, renewed by refresh tokens
+
+	AdminTotpRequired bool   `yaml:"AdminTotpRequired"` // admins without two-factor authentication can only enrol
+	TotpIssuer        string `yaml:"TotpIssuer"`        // account name shown in authenticator apps
+
+	// failed logins within AdminLockoutMinutes, a login is delayed after half of them
+	AdminMaxLoginFailures      int `yaml:"AdminMaxLoginFailures"`
+	AdminMaxLoginFailuresPerIp int `yaml:"AdminMaxLoginFailuresPerIp"`
+	AdminLockoutMinutes        int `yaml:"AdminLockoutMinutes"`
+
 	AwsKey    string `yaml:"AwsKey"`
 	AwsSecret string `yaml:"AwsSecret"`
 	AwsRegion string `yaml:"AwsRegion"`
 
+	// reason code: explanation for the applicant
+	DeclineReasons  map[string]string `yaml:"DeclineReasons"`
+	QuestionReasons map[string]string `yaml:"QuestionReasons"`
+
 	NoReplyEmail string `yaml:"NoReplyEmail"`
 	ReplyEmail   string `yaml:"ReplyEmail"`
 
+	PublicUrl    string `yaml:"PublicUrl"` // base of links in emails
+	SupportEmail string `yaml:"SupportEmail"` // support@mdl.life by default
+
+	EmailTemplatePath string `yaml:"EmailTemplatePath"` // a directory per language
+	DefaultLanguage   string `yaml:"DefaultLanguage"`
+
+	MailDriver   string `yaml:"MailDriver"` // ses, smtp or file
+	MailPath     string `yaml:"MailPath"`   // directory of the file driver
+	SmtpHost     string `yaml:"SmtpHost"`
+	SmtpPort     int    `yaml:"SmtpPort"`
+	SmtpUser     string `yaml:"SmtpUser"`
+	SmtpPassword string `yaml:"SmtpPassword"`
+
+	MailQueueIntervalSeconds int `yaml:"MailQueueIntervalSeconds"` // 0 disables the background worker
+	MailMaxAttempts          int `yaml:"MailMaxAttempts"`
+
 	DatabaseDriver string `yaml:"DatabaseDriver"`
 	DatabaseDSN    string `yaml:"DatabaseDSN"`
 
+	ResendConfirmationPerEmail int `yaml:"ResendConfirmationPerEmail"` // per hour
+	ResendConfirmationPerIp    int `yaml:"ResendConfirmationPerIp"`    // per hour
+	DataRequestPerEmail        int `yaml:"DataRequestPerEmail"`        // per hour
+	DataRequestPerIp           int `yaml:"DataRequestPerIp"`           // per hour
+
+	UnconfirmedGraceDays int `yaml:"UnconfirmedGraceDays"` // after the confirmation link expired
+	PurgeIntervalMinutes int `yaml:"PurgeIntervalMinutes"` // 0 disables the background jobs
+
+	ReviewLeaseMinutes int `yaml:"ReviewLeaseMinutes"` // a claimed application returns to the review queue after it
+
+	// stage name: days in the stage before personal data is erased, e.g. declined: 90
+	RetentionDays map[string]int `yaml:"RetentionDays"`
+
 	MaxFileUploadSizeMb int64 `yaml:"MaxFileUploadSizeMb"`
+	MinImageDimension   int   `yaml:"MinImageDimension"`  // pixels, 0 disables the check
+	MaxImageMegapixels  int   `yaml:"MaxImageMegapixels"` // 0 disables the check
+	StripImageMetadata  bool  `yaml:"StripImageMetadata"` // re-encode images to drop EXIF/GPS data
+
+	StorageDriver string `yaml:"StorageDriver"` // local or s3
+	StoragePath   string `yaml:"StoragePath"`   // root directory of the local driver
+
+	S3Endpoint       string `yaml:"S3Endpoint"`
+	S3Region         string `yaml:"S3Region"`
+	S3Bucket         string `yaml:"S3Bucket"`
+	S3Key            string `yaml:"S3Key"`
+	S3Secret         string `yaml:"S3Secret"`
+	S3ForcePathStyle bool   `yaml:"S3ForcePathStyle"`
+
+	// base64 encoded 32 bytes master keys, uploads are stored unencrypted without them
+	EncryptionKey     string   `yaml:"EncryptionKey"`
+	EncryptionKeyFile string   `yaml:"EncryptionKeyFile"`
+	EncryptionOldKeys []string `yaml:"EncryptionOldKeys"` // still readable until rotate-keys is done
 
 	Port string `yaml:"Port"`
 }
@@ -34,9 +97,6 @@ var Config = &config{}
 
 func init() {
 	loadConfig()
-
-	// Amazon SES setup
-	ses.SetConfiguration(Config.AwsKey, Config.AwsSecret, Config.AwsRegion)
 }
 
 func loadConfig() {
@@ -56,4 +116,21 @@ func loadConfig() {
 	if err != nil {
 		panic(err)
 	}
+
+	setDefaults()
+}
+
+// setDefaults fills settings the application can't work without
+func setDefaults() {
+	// the admin client falls back to the "other" reason code
+	if len(Config.DeclineReasons) == 0 {
+		Config.DeclineReasons = map[string]string{"other": "Your application does not meet our requirements."}
+	}
+	if len(Config.QuestionReasons) == 0 {
+		Config.QuestionReasons = map[string]string{"other": "We need more information about your application."}
+	}
+	// applicant emails link to it
+	if Config.SupportEmail == "" {
+		Config.SupportEmail = "support@mdl.life"
+	}
 }
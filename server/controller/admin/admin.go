@@ -4,68 +4,115 @@ import (
 	"github.com/kataras/iris"
 	"strconv"
 	"github.com/go-ozzo/ozzo-validation"
-	"os"
 	"fmt"
-	"bufio"
-	"encoding/base64"
 
+	"../../config"
 	"../../model"
 	"../../db"
 	"regexp"
+	"time"
+
+	"github.com/go-xorm/xorm"
 )
 
 var (
 	SortByRegex = regexp.MustCompile("^(id|name|country|birthday)$")
 	StageFilterRegex = regexp.MustCompile("^(all|unconfirmed|confirmed|declined|question|accepted)$")
+	AssignedFilterRegex = regexp.MustCompile("^(all|me|unassigned)$")
 )
 
-func GetWhitelistList(ctx iris.Context) {
-	var whitelists []model.WhitelistPassport
-	descending, _ := strconv.ParseBool(ctx.FormValue("descending"))
-	page, _ := strconv.Atoi(ctx.FormValueDefault("page", "1"))
-	rowsPerPage, _ := strconv.Atoi(ctx.FormValue("rowsPerPage"))
-	sortBy := ctx.FormValueDefault("sortBy", "id")
-	search := ctx.FormValue("search")
-	stageFilter := ctx.FormValueDefault("stage", "all")
+// listFilter holds the list parameters shared by GetWhitelistList and GetWhitelistExport.
+type listFilter struct {
+	SortBy     string
+	Descending bool
+	Search     string
+	Stage      string
+	Assigned   string // all, me or unassigned, claims of the review queue
+	AdminId    int64  // the admin of "me"
+}
 
-	query := db.Engine.NewSession()
+func readListFilter(ctx iris.Context) (listFilter, error) {
+	var f listFilter
+	f.Descending, _ = strconv.ParseBool(ctx.FormValue("descending"))
+	f.SortBy = ctx.FormValueDefault("sortBy", "id")
+	f.Search = ctx.FormValue("search")
+	f.Stage = ctx.FormValueDefault("stage", "all")
+	f.Assigned = ctx.FormValueDefault("assigned", "all")
+	if admin := CurrentAdmin(ctx); admin != nil {
+		f.AdminId = admin.Id
+	}
 
-	if err := validation.Validate(sortBy, validation.Match(SortByRegex)); err != nil {
-		ctx.StatusCode(iris.StatusUnprocessableEntity)
-		ctx.JSON(map[string]interface{}{"errors": err})
-		return
+	if err := validation.Validate(f.SortBy, validation.Match(SortByRegex)); err != nil {
+		return f, err
 	}
 
-	if err := validation.Validate(stageFilter, validation.Match(StageFilterRegex)); err != nil {
-		ctx.StatusCode(iris.StatusUnprocessableEntity)
-		ctx.JSON(map[string]interface{}{"errors": err})
-		return
+	if err := validation.Validate(f.Stage, validation.Match(StageFilterRegex)); err != nil {
+		return f, err
 	}
 
-	query = query.Table("whitelists").Alias("w")
-	if stageFilter == "all" {
+	if err := validation.Validate(f.Assigned, validation.Match(AssignedFilterRegex)); err != nil {
+		return f, err
+	}
+
+	return f, nil
+}
+
+// where adds the conditions of the filter to a query of whitelists aliased "w"
+func (f listFilter) where(query *xorm.Session) *xorm.Session {
+	if f.Stage == "all" {
 		query = query.Where("w.verification_stage >= ?", int(model.STAGE_EMAIL_CONFIRMED))
 	} else {
-		query = query.Where("w.verification_stage = ?", int(model.NewVerificationStageFromString(stageFilter)))
+		query = query.Where("w.verification_stage = ?", int(model.NewVerificationStageFromString(f.Stage)))
 	}
 
-	if search != "" {
-		query = query.And("w.name LIKE ?", "%" + search + "%")
+	if f.Search != "" {
+		query = query.And("w.name LIKE ?", "%" + f.Search + "%")
+	}
+
+	switch f.Assigned {
+	case "me":
+		query = query.And("w.assignee_id = ? AND w.assigned_until > ?", f.AdminId, time.Now())
+	case "unassigned":
+		query = query.And("(w.assignee_id = 0 OR w.assigned_until IS NULL OR w.assigned_until <= ?)", time.Now())
+	}
+
+	return query
+}
+
+func (f listFilter) order(query *xorm.Session) *xorm.Session {
+	if f.Descending {
+		return query.Desc("w." + f.SortBy)
+	}
+
+	return query.Asc("w." + f.SortBy)
+}
+
+func GetWhitelistList(ctx iris.Context) {
+	var whitelists []model.WhitelistPassport
+	page, _ := strconv.Atoi(ctx.FormValueDefault("page", "1"))
+	rowsPerPage, _ := strconv.Atoi(ctx.FormValue("rowsPerPage"))
+
+	filter, err := readListFilter(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": err})
+		return
 	}
 
+	query := db.Engine.NewSession()
+	defer query.Close()
+
+	query = filter.where(query.Table("whitelists").Alias("w"))
+
 	rowsNumber, err := query.Clone().Count(&model.Whitelist{})
 	if err != nil {
 		println("Can't count whitelists. " + err.Error())
 	}
 
 	// move below because it breaks count
-	query = query.Select("w.id, w.name, w.email, w.birthday, w.country, w.verification_stage, w.passport_id, p.id, p.path, p.extension")
+	query = query.Select("w.id, w.name, w.email, w.phone, w.address, w.birthday, w.country, w.citizenship, w.verification_stage, w.assignee_id, w.assigned_until, w.passport_id, p.id, p.extension")
 	query = query.Join("INNER", []string{"photos", "p"}, "p.id = w.passport_id")
-	if descending {
-		query = query.Desc("w."+sortBy)
-	} else {
-		query = query.Asc("w."+sortBy)
-	}
+	query = filter.order(query)
 	if rowsPerPage > 0 {
 		query = query.Limit(rowsPerPage, (page-1)*rowsPerPage)
 	}
@@ -74,80 +121,276 @@ func GetWhitelistList(ctx iris.Context) {
 		println("Can't receive whitelists. " + err.Error())
 	}
 
-	for i := 0; i < len(whitelists); i++ {
-		imgFile, err := os.Open(whitelists[i].Passport.Path)
+	// images are served by GetSignedPhoto, the list links them only, viewers don't see documents
+	admin := AdminName(ctx)
+	documents := CurrentAdmin(ctx).Can(model.PERMISSION_WHITELIST_DOCUMENTS)
+	for i := 0; i < len(whitelists) && documents; i++ {
+		whitelists[i].Passport.Src = SignedPhotoURL(whitelists[i].Passport.Id, "thumb", admin)
+		whitelists[i].Passport.Url = SignedPhotoURL(whitelists[i].Passport.Id, "full", admin)
+	}
 
-		if err != nil {
-			fmt.Printf("Can't open photoId: %v \n\t %s", whitelists[i].Passport.Id, err.Error())
-			continue
+	ctx.JSON(map[string]interface{}{"data": whitelists, "pagination": map[string]interface{}{
+		"descending":  filter.Descending,
+		"page":        page,
+		"rowsPerPage": rowsPerPage,
+		"rowsNumber":  rowsNumber,
+		"sortBy":      filter.SortBy,
+	}})
+}
+
+func GetWhitelist(ctx iris.Context) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	writeWhitelistDetail(ctx, id)
+}
+
+// writeWhitelistDetail responds with the application and signed URLs of its documents
+func writeWhitelistDetail(ctx iris.Context, id int64) {
+	whitelist, has, err := model.GetWhitelistDetail(id)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't receive whitelist id: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return
+	}
+
+	// viewers see the application without documents
+	admin := AdminName(ctx)
+	documents := CurrentAdmin(ctx).Can(model.PERMISSION_WHITELIST_DOCUMENTS)
+	for _, photo := range []*model.Photo{whitelist.Passport, whitelist.Selfie, whitelist.ResidentialPhoto, whitelist.StatementPhoto} {
+		if photo != nil && documents {
+			photo.Src = SignedPhotoURL(photo.Id, "thumb", admin)
+			photo.Url = SignedPhotoURL(photo.Id, "full", admin)
 		}
+	}
 
-		defer imgFile.Close()
+	ctx.JSON(map[string]interface{}{"data": whitelist})
+}
 
-		// create a new buffer base on file size
-		fInfo, _ := imgFile.Stat()
-		var size int64 = fInfo.Size()
-		buf := make([]byte, size)
+func WhitelistAccept(ctx iris.Context) {
+	changeStage(ctx, model.StageChange{To: model.STAGE_ACCEPTED}, "accept")
+}
 
-		// read file content into buffer
-		fReader := bufio.NewReader(imgFile)
-		fReader.Read(buf)
+func WhitelistDecline(ctx iris.Context) {
+	changeStage(ctx, model.StageChange{To: model.STAGE_DECLINED}, "decline")
+}
 
-		// convert the buffer bytes to base64 string
-		imgBase64Str := base64.StdEncoding.EncodeToString(buf)
+func WhitelistQuestion(ctx iris.Context) {
+	changeStage(ctx, model.StageChange{To: model.STAGE_QUESTION}, "mark by a question")
+}
 
-		whitelists[i].Passport.Src = "data:image/" + whitelists[i].Passport.Extension + ";base64," + imgBase64Str
-	}
+// decision is the JSON body of accept, decline and question requests
+type decision struct {
+	Reason             string `json:"reason"` // code from the config, required for decline and question
+	Note               string `json:"note"`
+	ShareWithApplicant bool   `json:"shareWithApplicant"`
+	SuppressEmail      bool   `json:"suppressEmail"` // decide without notifying the applicant
+}
 
-	ctx.JSON(map[string]interface{}{"data": whitelists, "pagination": map[string]interface{}{
-		"descending":  descending,
-		"page":        page,
-		"rowsPerPage": rowsPerPage,
-		"rowsNumber":  rowsNumber,
-		"sortBy":      sortBy,
+// GetDecisionReasons lists reason codes accepted by decline and question requests.
+func GetDecisionReasons(ctx iris.Context) {
+	ctx.JSON(map[string]interface{}{"data": map[string]interface{}{
+		"decline":  config.Config.DeclineReasons,
+		"question": config.Config.QuestionReasons,
 	}})
 }
 
-func WhitelistAccept(ctx iris.Context) {
-	id := ctx.Params().Get("id")
+func WhitelistNote(ctx iris.Context) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	var body struct {
+		Note string `json:"note"`
+	}
+	if err := ctx.ReadJSON(&body); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		return
+	}
 
-	whitelist := &model.Whitelist{VerificationStage: model.STAGE_ACCEPTED}
-	i, err := db.Engine.ID(id).Cols("verification_stage").Update(whitelist)
-	if err != nil || i == 0 {
+	if err := validation.Validate(body.Note, validation.Required, validation.Length(1, 2000)); err != nil {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": map[string]error{"note": err}})
+		return
+	}
+
+	has, err := db.Engine.ID(id).Exist(&model.Whitelist{})
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find whitelist id: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
 		ctx.StatusCode(iris.StatusNotFound)
-		fmt.Printf("Can't accept whitelist id: %v \n\t %s", id, err)
 		return
 	}
+
+	note := &model.WhitelistNote{WhitelistId: id, Author: AdminName(ctx), Note: body.Note}
+	if err := note.Store(); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't store note of whitelist id: %v \n\t %s", id, err)
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": note})
 }
 
-func WhitelistDecline(ctx iris.Context) {
-	id := ctx.Params().Get("id")
-
-	whitelist := &model.Whitelist{VerificationStage: model.STAGE_DECLINED}
-	i, err := db.Engine.
-		ID(id).
-		Where("verification_stage < ?", int(model.STAGE_ACCEPTED)).
-		Cols("verification_stage").
-		Update(whitelist)
-	if err != nil || i == 0 {
+func GetWhitelistHistory(ctx iris.Context) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	has, err := db.Engine.ID(id).Exist(&model.Whitelist{})
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find whitelist id: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
 		ctx.StatusCode(iris.StatusNotFound)
-		fmt.Printf("Can't decline whitelist id: %v \n\t %s", id, err)
 		return
 	}
+
+	events, err := model.GetStageEvents(id)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't receive stage events of whitelist id: %v \n\t %s", id, err)
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": events})
 }
 
-func WhitelistQuestion(ctx iris.Context) {
-	id := ctx.Params().Get("id")
-
-	whitelist := &model.Whitelist{VerificationStage: model.STAGE_QUESTION}
-	i, err := db.Engine.
-		ID(id).
-		Where("verification_stage < ?", int(model.STAGE_ACCEPTED)).
-		Cols("verification_stage").
-		Update(whitelist)
-	if err != nil || i == 0 {
+// GetWhitelistData streams the data export of the applicant as a ZIP file.
+func GetWhitelistData(ctx iris.Context) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	whitelist, has, err := model.GetWhitelist(id)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find whitelist id: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return
+	}
+
+	ctx.ContentType("application/zip")
+	ctx.Header("Content-Disposition", "attachment; filename=\"whitelist-"+strconv.FormatInt(id, 10)+".zip\"")
+	ctx.Header("Cache-Control", "no-store")
+
+	// the status is sent with the first bytes, errors can only be logged
+	if err := whitelist.WriteArchive(ctx.ResponseWriter(), false); err != nil {
+		fmt.Printf("Can't export whitelist id: %v \n\t %s", id, err)
+	}
+}
+
+// WhitelistErase deletes documents and personal data of the applicant, the anonymized record stays.
+func WhitelistErase(ctx iris.Context) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	whitelist := &model.Whitelist{}
+	has, err := db.Engine.ID(id).Get(whitelist)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find whitelist id: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
 		ctx.StatusCode(iris.StatusNotFound)
-		fmt.Printf("Can't mark by a question whitelist id: %v \n\t %s", id, err)
 		return
 	}
+
+	switch err := whitelist.Erase(AdminName(ctx)); err {
+	case nil:
+		ctx.JSON(map[string]interface{}{"data": whitelist})
+	case model.ErrErased:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"stage": err.Error()}})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't erase whitelist id: %v \n\t %s", id, err)
+	}
+}
+
+// changeStage moves the whitelist from the route to another stage through the transition table
+func changeStage(ctx iris.Context, change model.StageChange, action string) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	whitelist := &model.Whitelist{}
+	has, err := db.Engine.ID(id).Get(whitelist)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't %s whitelist id: %v \n\t %s", action, id, err)
+		return
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return
+	}
+
+	var body decision
+	if ctx.Request().ContentLength != 0 {
+		if err := ctx.ReadJSON(&body); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			return
+		}
+	}
+
+	if errs := body.validate(change.To); errs != nil {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": errs})
+		return
+	}
+
+	// claims of the review queue keep reviewers from deciding the same application
+	change.AdminId = CurrentAdmin(ctx).Id
+	change.Actor = AdminName(ctx)
+	change.Ip = ctx.RemoteAddr()
+	change.Reason = body.Reason
+	change.Note = body.Note
+	change.ShareWithApplicant = body.ShareWithApplicant
+	change.SuppressEmail = body.SuppressEmail
+
+	err = whitelist.ChangeStage(change)
+	switch err.(type) {
+	case nil:
+		return
+	case *model.TransitionError:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"stage": err.Error()}})
+		return
+	}
+
+	switch err {
+	case model.ErrStageChanged, model.ErrErased:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"stage": err.Error()}})
+	case model.ErrAssignedToOther:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"assignee": err.Error()}})
+	case model.ErrReasonRequired:
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"reason": err.Error()}})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't %s whitelist id: %v \n\t %s", action, id, err)
+	}
+}
+
+// validate checks the reason code against the configured list of the stage
+func (d decision) validate(to model.VerificationStage) error {
+	var codes []interface{}
+	reasons := map[model.VerificationStage]map[string]string{
+		model.STAGE_DECLINED: config.Config.DeclineReasons,
+		model.STAGE_QUESTION: config.Config.QuestionReasons,
+	}
+	for code := range reasons[to] {
+		codes = append(codes, code)
+	}
+
+	return validation.Errors{
+		"reason": validation.Validate(d.Reason, validation.In(codes...).Error("unknown reason code")),
+		"note":   validation.Validate(d.Note, validation.Length(0, 2000)),
+	}.Filter()
 }
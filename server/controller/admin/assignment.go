@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kataras/iris"
+
+	"../../config"
+	"../../db"
+	"../../model"
+)
+
+func reviewLease() time.Duration {
+	if config.Config.ReviewLeaseMinutes > 0 {
+		return time.Duration(config.Config.ReviewLeaseMinutes) * time.Minute
+	}
+
+	return 30 * time.Minute
+}
+
+// WhitelistNext claims the oldest confirmed application for the reviewer, 204 when nothing waits for review.
+// The claim expires after ReviewLeaseMinutes unless the reviewer decides or calls it again.
+func WhitelistNext(ctx iris.Context) {
+	admin := CurrentAdmin(ctx)
+
+	whitelist, has, err := model.ClaimNextWhitelist(admin.Id, reviewLease())
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't claim a whitelist for admin id: %v \n\t %s", admin.Id, err)
+		return
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNoContent)
+		return
+	}
+
+	writeWhitelistDetail(ctx, whitelist.Id)
+}
+
+// WhitelistRelease returns a claimed application to the queue, admins who assign can release any claim.
+func WhitelistRelease(ctx iris.Context) {
+	whitelist, ok := routeWhitelist(ctx)
+	if !ok {
+		return
+	}
+
+	var err error
+	if admin := CurrentAdmin(ctx); admin.Can(model.PERMISSION_WHITELIST_ASSIGN) {
+		err = whitelist.Assign(0, 0)
+	} else {
+		err = whitelist.Release(admin.Id)
+	}
+	if !assignmentError(ctx, whitelist, err) {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": whitelist})
+}
+
+// WhitelistAssign hands an application in review to another reviewer with a new lease, adminId 0 releases it.
+func WhitelistAssign(ctx iris.Context) {
+	var body struct {
+		AdminId int64 `json:"adminId"`
+	}
+	if err := ctx.ReadJSON(&body); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		return
+	}
+
+	whitelist, ok := routeWhitelist(ctx)
+	if !ok {
+		return
+	}
+
+	if body.AdminId != 0 {
+		if whitelist.VerificationStage != model.STAGE_EMAIL_CONFIRMED {
+			ctx.StatusCode(iris.StatusConflict)
+			ctx.JSON(map[string]interface{}{"errors": map[string]string{"stage": "Only applications in review can be assigned"}})
+			return
+		}
+
+		assignee, has, err := model.GetAdmin(body.AdminId)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			fmt.Printf("Can't find admin id: %v \n\t %s", body.AdminId, err)
+			return
+		}
+		if !has || assignee.Disabled || !assignee.Can(model.PERMISSION_WHITELIST_CLAIM) {
+			ctx.StatusCode(iris.StatusUnprocessableEntity)
+			ctx.JSON(map[string]interface{}{"errors": map[string]string{"adminId": "The admin can't review applications"}})
+			return
+		}
+	}
+
+	if !assignmentError(ctx, whitelist, whitelist.Assign(body.AdminId, reviewLease())) {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": whitelist})
+}
+
+// routeWhitelist loads the whitelist of the {id} route parameter.
+func routeWhitelist(ctx iris.Context) (*model.Whitelist, bool) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	whitelist := &model.Whitelist{}
+	has, err := db.Engine.ID(id).Get(whitelist)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't receive whitelist id: %v \n\t %s", id, err)
+		return nil, false
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return nil, false
+	}
+
+	return whitelist, true
+}
+
+// assignmentError writes the response of a failed assignment, it returns true if there is no error.
+func assignmentError(ctx iris.Context, whitelist *model.Whitelist, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case model.ErrNotAssigned:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"assignee": err.Error()}})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't change the assignee of whitelist id: %v \n\t %s", whitelist.Id, err)
+	}
+
+	return false
+}
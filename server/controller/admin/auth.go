@@ -0,0 +1,453 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+	"github.com/kataras/iris"
+
+	"../../config"
+	"../../model"
+)
+
+// Authenticate lets requests with an access token or basic auth of an enabled admin through
+// and keeps the admin on the context, see CurrentAdmin. Basic auth is left for scripts, the client uses tokens.
+func Authenticate(ctx iris.Context) {
+	admin, err := authenticate(ctx)
+	if err == errThrottled {
+		ctx.StopExecution()
+		return
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't authenticate admin. " + err.Error())
+		ctx.StopExecution()
+		return
+	}
+	if admin == nil {
+		if bearerToken(ctx) == "" {
+			ctx.Header("WWW-Authenticate", "Basic realm=\"Authorization Required\"")
+		}
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.StopExecution()
+		return
+	}
+
+	ctx.Values().Set("admin", admin)
+	ctx.Next()
+}
+
+func authenticate(ctx iris.Context) (*model.Admin, error) {
+	if token := bearerToken(ctx); token != "" {
+		admin, has, err := model.GetTokenAdmin(token)
+		if err != nil || !has {
+			return nil, err
+		}
+
+		return admin, nil
+	}
+
+	login, password, ok := ctx.Request().BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	if throttled, err := throttle(ctx, login); err != nil || throttled {
+		return nil, err
+	}
+
+	admin, err := model.Authenticate(login, password)
+	if err == model.ErrInvalidCredentials {
+		return nil, model.RecordLoginFailure(login, 0, ctx.RemoteAddr(), model.LOGIN_PASSWORD)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// basic auth can't carry a second factor, enrolled admins log in with Login
+	if admin.TotpEnabled {
+		return nil, nil
+	}
+
+	return admin, model.RecordLoginSuccess(admin, ctx.RemoteAddr(), false)
+}
+
+// errThrottled tells that the 429 response has been written
+var errThrottled = errors.New("too many failed logins")
+
+// throttle rejects attempts of a login or IP during its delay or lockout with 429, they are audited
+// but not counted as failures.
+func throttle(ctx iris.Context, login string) (bool, error) {
+	wait, err := model.LoginWait(login, ctx.RemoteAddr())
+	if err != nil || wait <= 0 {
+		return false, err
+	}
+
+	if err = model.RecordLoginFailure(login, 0, ctx.RemoteAddr(), model.LOGIN_THROTTLED); err != nil {
+		return false, err
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+	ctx.StatusCode(iris.StatusTooManyRequests)
+	ctx.JSON(map[string]interface{}{"errors": map[string]string{
+		"login": fmt.Sprintf("Too many failed logins, try again in %v seconds", seconds),
+	}})
+
+	return true, errThrottled
+}
+
+func bearerToken(ctx iris.Context) string {
+	header := ctx.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len("Bearer "):])
+}
+
+// Require lets only admins with the permission through, the 403 response names the missing permission.
+// Admins who have to enrol into two-factor authentication are only allowed to do so.
+func Require(permission model.Permission) iris.Handler {
+	return func(ctx iris.Context) {
+		admin := CurrentAdmin(ctx)
+		if admin != nil && totpEnrolmentRequired(admin) {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.JSON(map[string]interface{}{"errors": map[string]string{"totp": "Two-factor authentication is required"}})
+			ctx.StopExecution()
+			return
+		}
+
+		if admin == nil || !admin.Can(permission) {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.JSON(map[string]interface{}{"errors": map[string]string{"permission": string(permission)}})
+			ctx.StopExecution()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// CurrentAdmin returns the admin authenticated by the Authenticate middleware.
+func CurrentAdmin(ctx iris.Context) *model.Admin {
+	admin, _ := ctx.Values().Get("admin").(*model.Admin)
+	return admin
+}
+
+// AdminName returns the login of the authenticated admin.
+func AdminName(ctx iris.Context) string {
+	if admin := CurrentAdmin(ctx); admin != nil {
+		return admin.Login
+	}
+
+	return ""
+}
+
+// sessionTtl is the lifetime of a refresh token, the admin logs in again after it
+func sessionTtl() time.Duration {
+	if config.Config.AdminSessionTtlMinutes > 0 {
+		return time.Duration(config.Config.AdminSessionTtlMinutes) * time.Minute
+	}
+
+	return 12 * time.Hour
+}
+
+func accessTokenTtl() time.Duration {
+	if config.Config.AdminAccessTokenTtlMinutes > 0 {
+		return time.Duration(config.Config.AdminAccessTokenTtlMinutes) * time.Minute
+	}
+
+	return 15 * time.Minute
+}
+
+// Login exchanges a login and password for an access token, send it as "Authorization: Bearer <token>",
+// and a refresh token for Refresh.
+// Admins with two-factor authentication send a TOTP or recovery code too, without it the response is
+// 401 with "totpRequired" so the client can ask for the code.
+func Login(ctx iris.Context) {
+	var body struct {
+		Login    string `json:"login"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := ctx.ReadJSON(&body); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		return
+	}
+
+	if _, err := throttle(ctx, body.Login); err == errThrottled {
+		return
+	} else if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't check login attempts. " + err.Error())
+		return
+	}
+
+	admin, err := model.Authenticate(body.Login, body.Password)
+	if err == model.ErrInvalidCredentials {
+		if err := model.RecordLoginFailure(body.Login, 0, ctx.RemoteAddr(), model.LOGIN_PASSWORD); err != nil {
+			println("Can't record login attempt. " + err.Error())
+		}
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"login": model.ErrInvalidCredentials.Error()}})
+		return
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't authenticate admin. " + err.Error())
+		return
+	}
+
+	if admin.TotpEnabled && body.Code == "" {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"code": "A one-time code is required"}, "totpRequired": true})
+		return
+	}
+	if err = admin.VerifySecondFactor(body.Code); err == model.ErrInvalidCode {
+		if err := model.RecordLoginFailure(admin.Login, admin.Id, ctx.RemoteAddr(), model.LOGIN_CODE); err != nil {
+			println("Can't record login attempt. " + err.Error())
+		}
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"code": err.Error()}, "totpRequired": true})
+		return
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't verify the code of admin id: %v \n\t %s", admin.Id, err)
+		return
+	}
+
+	if err = model.RecordLoginSuccess(admin, ctx.RemoteAddr(), true); err != nil {
+		println("Can't record login attempt. " + err.Error())
+	}
+
+	tokens, err := admin.NewSession(accessTokenTtl(), sessionTtl(), ctx.RemoteAddr())
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't create session of admin id: %v \n\t %s", admin.Id, err)
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": sessionData(admin, tokens)})
+}
+
+// Refresh exchanges a refresh token for new tokens before the access token expires.
+func Refresh(ctx iris.Context) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := ctx.ReadJSON(&body); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		return
+	}
+
+	admin, tokens, err := model.RefreshSession(body.RefreshToken, accessTokenTtl(), sessionTtl(), ctx.RemoteAddr())
+	if err == model.ErrInvalidToken {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"refreshToken": err.Error()}})
+		return
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't refresh admin session. " + err.Error())
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": sessionData(admin, tokens)})
+}
+
+func sessionData(admin *model.Admin, tokens *model.SessionTokens) map[string]interface{} {
+	return map[string]interface{}{
+		"accessToken":      tokens.AccessToken,
+		"accessExpiredAt":  tokens.AccessExpiredAt,
+		"refreshToken":     tokens.RefreshToken,
+		"refreshExpiredAt": tokens.RefreshExpiredAt,
+		"admin":            admin,
+		"permissions":      model.RolePermissions(admin.Role),
+		// the client sends the admin to the enrolment first
+		"totpEnrolmentRequired": totpEnrolmentRequired(admin),
+	}
+}
+
+// Logout revokes the refresh token, the access token expires on its own.
+func Logout(ctx iris.Context) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := ctx.ReadJSON(&body); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		return
+	}
+
+	err := model.RevokeSession(body.RefreshToken)
+	if err == model.ErrInvalidToken {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"refreshToken": err.Error()}})
+		return
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't revoke admin session. " + err.Error())
+		return
+	}
+
+	ctx.JSON(map[string]bool{"success": true})
+}
+
+// GetCurrentAdmin returns the logged in admin and the permissions of the role.
+func GetCurrentAdmin(ctx iris.Context) {
+	admin := CurrentAdmin(ctx)
+
+	ctx.JSON(map[string]interface{}{"data": map[string]interface{}{
+		"admin":                 admin,
+		"permissions":           model.RolePermissions(admin.Role),
+		"totpEnrolmentRequired": totpEnrolmentRequired(admin),
+	}})
+}
+
+func GetAdminList(ctx iris.Context) {
+	admins, err := model.GetAdmins()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't receive admins. " + err.Error())
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": admins})
+}
+
+func CreateAdmin(ctx iris.Context) {
+	var body struct {
+		Login    string `json:"login"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := ctx.ReadJSON(&body); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		return
+	}
+
+	admin, err := model.NewAdmin(strings.TrimSpace(body.Login), body.Password, body.Role)
+	if !adminError(ctx, err, "create") {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": admin})
+}
+
+func DisableAdmin(ctx iris.Context) {
+	setDisabled(ctx, true)
+}
+
+func EnableAdmin(ctx iris.Context) {
+	setDisabled(ctx, false)
+}
+
+func setDisabled(ctx iris.Context, disabled bool) {
+	admin, ok := routeAdmin(ctx)
+	if !ok {
+		return
+	}
+
+	if disabled && admin.Id == CurrentAdmin(ctx).Id {
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"admin": "You can't disable your own account"}})
+		return
+	}
+
+	if !adminError(ctx, admin.SetDisabled(disabled), "disable") {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": admin})
+}
+
+// SetAdminRole changes the role of an admin, nobody can change their own role.
+func SetAdminRole(ctx iris.Context) {
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := ctx.ReadJSON(&body); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		return
+	}
+
+	admin, ok := routeAdmin(ctx)
+	if !ok {
+		return
+	}
+
+	if admin.Id == CurrentAdmin(ctx).Id {
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"admin": "You can't change your own role"}})
+		return
+	}
+
+	if !adminError(ctx, admin.SetRole(body.Role), "change role of") {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": admin})
+}
+
+// ResetAdminPassword sets a random password and returns it once, existing sessions end.
+func ResetAdminPassword(ctx iris.Context) {
+	admin, ok := routeAdmin(ctx)
+	if !ok {
+		return
+	}
+
+	password, err := admin.ResetPassword()
+	if !adminError(ctx, err, "reset") {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": map[string]interface{}{"admin": admin, "password": password}})
+}
+
+// routeAdmin loads the admin of the {id} route parameter.
+func routeAdmin(ctx iris.Context) (*model.Admin, bool) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	admin, has, err := model.GetAdmin(id)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find admin id: %v \n\t %s", id, err)
+		return nil, false
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return nil, false
+	}
+
+	return admin, true
+}
+
+// adminError writes the response of a failed admin operation, it returns true if there is no error.
+func adminError(ctx iris.Context, err error, action string) bool {
+	if err == nil {
+		return true
+	}
+
+	if errs, ok := err.(validation.Errors); ok {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": errs})
+		return false
+	}
+
+	if err == model.ErrAdminExists {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"login": err.Error()}})
+		return false
+	}
+
+	ctx.StatusCode(iris.StatusInternalServerError)
+	fmt.Printf("Can't %s admin \n\t %s", action, err)
+	return false
+}
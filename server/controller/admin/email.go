@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/go-ozzo/ozzo-validation"
+	"github.com/kataras/iris"
+
+	"../../db"
+	"../../email"
+	"../../model"
+)
+
+var EmailStatusRegex = regexp.MustCompile("^(all|pending|sent|failed|suppressed)$")
+
+// GetEmailList shows the outbox, newest first, e.g. ?status=failed to find emails to re-send.
+func GetEmailList(ctx iris.Context) {
+	var emails []model.WhitelistEmail
+	page, _ := strconv.Atoi(ctx.FormValueDefault("page", "1"))
+	rowsPerPage, _ := strconv.Atoi(ctx.FormValue("rowsPerPage"))
+	status := ctx.FormValueDefault("status", "all")
+
+	if err := validation.Validate(status, validation.Match(EmailStatusRegex)); err != nil {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": err})
+		return
+	}
+
+	query := db.Engine.NewSession()
+	defer query.Close()
+
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+
+	rowsNumber, err := query.Clone().Count(&model.WhitelistEmail{})
+	if err != nil {
+		println("Can't count emails. " + err.Error())
+	}
+
+	query = query.Omit("text", "html").Desc("id")
+	if rowsPerPage > 0 {
+		query = query.Limit(rowsPerPage, (page-1)*rowsPerPage)
+	}
+
+	if err := query.Find(&emails); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't receive emails. " + err.Error())
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": emails, "pagination": map[string]interface{}{
+		"page":        page,
+		"rowsPerPage": rowsPerPage,
+		"rowsNumber":  rowsNumber,
+	}})
+}
+
+// ResendEmail queues a failed email again and tries to send only this one right away.
+func ResendEmail(ctx iris.Context) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	e, has, err := model.GetEmail(id)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't receive email id: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return
+	}
+
+	queued, err := e.Resend()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't re-send email id: %v \n\t %s", id, err)
+		return
+	}
+	if !queued {
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"status": "Only failed emails can be re-sent"}})
+		return
+	}
+
+	if _, err := email.DeliverEmail(e); err != nil {
+		fmt.Printf("Can't deliver email id: %v \n\t %s", id, err)
+	}
+
+	e, _, err = model.GetEmail(id)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't receive email id: %v \n\t %s", id, err)
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": e})
+}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+	"github.com/kataras/iris"
+
+	"../../db"
+	"../../model"
+	"../../spreadsheet"
+)
+
+var ExportFormatRegex = regexp.MustCompile("^(csv|xlsx)$")
+
+var exportColumns = []string{
+	"Id", "Name", "Email", "Phone", "Address", "Birthday", "Country", "Citizenship", "Language", "Stage",
+	"Created at", "Confirmed at", "Question at", "Declined at", "Accepted at", "Updated at", "Erased at",
+}
+
+// GetWhitelistExport streams every application matching the list filters as CSV or XLSX, e.g. ?format=xlsx&stage=accepted.
+func GetWhitelistExport(ctx iris.Context) {
+	format := ctx.FormValueDefault("format", "csv")
+	if err := validation.Validate(format, validation.Match(ExportFormatRegex)); err != nil {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": map[string]error{"format": err}})
+		return
+	}
+
+	filter, err := readListFilter(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": err})
+		return
+	}
+
+	filename := "whitelist-" + time.Now().Format("2006-01-02") + "." + format
+	ctx.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	ctx.Header("Cache-Control", "no-store")
+
+	var sheet spreadsheet.Writer
+	if format == "xlsx" {
+		ctx.ContentType("application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		if sheet, err = spreadsheet.NewXLSX(ctx.ResponseWriter()); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			println("Can't start export. " + err.Error())
+			return
+		}
+	} else {
+		ctx.ContentType("text/csv; charset=utf-8")
+		sheet = spreadsheet.NewCSV(ctx.ResponseWriter())
+	}
+
+	// the status is sent with the first bytes, errors can only be logged
+	if err := writeExport(sheet, filter); err != nil {
+		println("Can't export whitelists. " + err.Error())
+		return
+	}
+
+	if err := sheet.Close(); err != nil {
+		println("Can't export whitelists. " + err.Error())
+	}
+}
+
+// writeExport reads the applications page by page, so the whole list is never in memory.
+func writeExport(sheet spreadsheet.Writer, filter listFilter) error {
+	const pageSize = 500
+
+	if err := sheet.Write(exportColumns); err != nil {
+		return err
+	}
+
+	for page := 0; ; page++ {
+		var whitelists []model.Whitelist
+
+		query := db.Engine.NewSession()
+		query = filter.where(query.Table("whitelists").Alias("w"))
+		err := filter.order(query).Asc("w.id").Limit(pageSize, page*pageSize).Find(&whitelists)
+		query.Close()
+		if err != nil {
+			return err
+		}
+		if len(whitelists) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, len(whitelists))
+		for i, w := range whitelists {
+			ids[i] = w.Id
+		}
+
+		times, err := model.GetStageTimes(ids)
+		if err != nil {
+			return err
+		}
+
+		for _, w := range whitelists {
+			stages := times[w.Id]
+			row := []string{
+				strconv.FormatInt(w.Id, 10), w.Name, w.Email, w.Phone, w.Address, w.Birthday, w.Country, w.Citizenship,
+				w.Language, w.VerificationStage.String(),
+				exportTime(w.CreatedAt),
+				exportTime(stages[model.STAGE_EMAIL_CONFIRMED]),
+				exportTime(stages[model.STAGE_QUESTION]),
+				exportTime(stages[model.STAGE_DECLINED]),
+				exportTime(stages[model.STAGE_ACCEPTED]),
+				exportTime(w.UpdatedAt),
+				exportTime(w.ErasedAt.Time),
+			}
+			if err := sheet.Write(row); err != nil {
+				return fmt.Errorf("Can't write whitelist id: %v %s", w.Id, err)
+			}
+		}
+
+		if len(whitelists) < pageSize {
+			return nil
+		}
+	}
+}
+
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kataras/iris"
+
+	"../../db"
+	"../../model"
+)
+
+// GetLockouts lists logins and IPs with recent failed logins, delayed or locked out.
+func GetLockouts(ctx iris.Context) {
+	lockouts, err := model.GetLockouts()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't receive lockouts. " + err.Error())
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": lockouts})
+}
+
+// ClearLockout allows the next login of a locked out admin or IP at once.
+func ClearLockout(ctx iris.Context) {
+	id, _ := ctx.Params().GetInt64("id")
+
+	has, err := model.ClearLockout(id)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't clear lockout id: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return
+	}
+
+	ctx.JSON(map[string]bool{"success": true})
+}
+
+// GetLoginAttempts shows the audit log of admin logins, newest first, e.g. ?login=alice or ?ip=203.0.113.7.
+func GetLoginAttempts(ctx iris.Context) {
+	var attempts []model.AdminLoginAttempt
+	page, _ := strconv.Atoi(ctx.FormValueDefault("page", "1"))
+	rowsPerPage, _ := strconv.Atoi(ctx.FormValue("rowsPerPage"))
+
+	query := db.Engine.NewSession()
+	defer query.Close()
+
+	if login := ctx.FormValue("login"); login != "" {
+		query = query.Where("login = ?", login)
+	}
+	if ip := ctx.FormValue("ip"); ip != "" {
+		query = query.And("ip = ?", ip)
+	}
+
+	rowsNumber, err := query.Clone().Count(&model.AdminLoginAttempt{})
+	if err != nil {
+		println("Can't count login attempts. " + err.Error())
+	}
+
+	query = query.Desc("id")
+	if rowsPerPage > 0 {
+		query = query.Limit(rowsPerPage, (page-1)*rowsPerPage)
+	}
+
+	if err := query.Find(&attempts); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't receive login attempts. " + err.Error())
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": attempts, "pagination": map[string]interface{}{
+		"page":        page,
+		"rowsPerPage": rowsPerPage,
+		"rowsNumber":  rowsNumber,
+	}})
+}
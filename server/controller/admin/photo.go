@@ -0,0 +1,137 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+	"github.com/kataras/iris"
+
+	"../../config"
+	"../../db"
+	"../../model"
+	"../../signer"
+	"../../storage"
+)
+
+var PhotoSizeRegex = regexp.MustCompile("^(thumb|full)$")
+
+func GetPhoto(ctx iris.Context) {
+	id, _ := ctx.Params().GetInt64("id")
+	size := ctx.URLParamDefault("size", "full")
+
+	if err := validation.Validate(size, validation.Match(PhotoSizeRegex)); err != nil {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": map[string]error{"size": err}})
+		return
+	}
+
+	photo := &model.Photo{}
+	has, err := db.Engine.ID(id).Get(photo)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find photoId: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return
+	}
+
+	servePhoto(ctx, photo, size)
+}
+
+// servePhoto serves the original or the thumbnail, supports Range and conditional requests.
+// The file is read into memory, AES-GCM authenticates an encrypted blob only as a whole.
+func servePhoto(ctx iris.Context, photo *model.Photo, size string) {
+	var (
+		data []byte
+		err  error
+	)
+
+	contentType := photo.ContentType()
+	// older uploads have no thumbnail, the original is served instead
+	if size == "thumb" && photo.ThumbPath != "" {
+		data, err = photo.ReadThumb()
+		contentType = "image/jpeg"
+	} else {
+		size = "full"
+		data, err = photo.ReadFile()
+	}
+
+	if err == storage.ErrNotExist {
+		ctx.StatusCode(iris.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't read photoId: %v \n\t %s", photo.Id, err)
+		return
+	}
+
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("ETag", photoETag(photo, size))
+	ctx.Header("Cache-Control", "private, no-cache")
+	ctx.Header("X-Content-Type-Options", "nosniff")
+
+	// the reader seeks over the decrypted buffer for ranges, without copying it
+	http.ServeContent(ctx.ResponseWriter(), ctx.Request(), "", photo.CreatedAt, bytes.NewReader(data))
+}
+
+// photoETag is stable because stored files never change
+func photoETag(photo *model.Photo, size string) string {
+	hash := photo.OriginalHash
+	if len(hash) > 16 {
+		hash = hash[:16]
+	}
+
+	return fmt.Sprintf("\"%v-%s-%s\"", photo.Id, size, hash)
+}
+
+// GetSignedPhoto serves photos without authentication, the URL must be signed by SignedPhotoURL.
+func GetSignedPhoto(ctx iris.Context) {
+	query := ctx.Request().URL.Query()
+
+	if err := signer.Verify(ctx.Request().URL.Path, query); err != nil {
+		ctx.StatusCode(iris.StatusForbidden)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"signature": err.Error()}})
+		return
+	}
+
+	size := query.Get("size")
+	if err := validation.Validate(size, validation.Required, validation.Match(PhotoSizeRegex)); err != nil {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": map[string]error{"size": err}})
+		return
+	}
+
+	id, _ := ctx.Params().GetInt64("id")
+
+	photo := &model.Photo{}
+	has, err := db.Engine.ID(id).Get(photo)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find photoId: %v \n\t %s", id, err)
+		return
+	}
+	if !has {
+		ctx.StatusCode(iris.StatusNotFound)
+		return
+	}
+
+	servePhoto(ctx, photo, size)
+}
+
+// SignedPhotoURL returns an expiring URL of a photo minted for the admin.
+func SignedPhotoURL(id int64, size string, admin string) string {
+	ttl := time.Duration(config.Config.SignedUrlTtlMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+
+	return signer.URL(fmt.Sprintf("/photo/%v", id), url.Values{"size": {size}, "admin": {admin}}, ttl)
+}
@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"fmt"
+
+	"github.com/kataras/iris"
+
+	"../../config"
+	"../../model"
+)
+
+// totpEnrolmentRequired is true while an admin lacks the enforced two-factor authentication.
+func totpEnrolmentRequired(admin *model.Admin) bool {
+	return config.Config.AdminTotpRequired && !admin.TotpEnabled
+}
+
+// GetTotp shows the two-factor authentication state of the current admin.
+func GetTotp(ctx iris.Context) {
+	admin := CurrentAdmin(ctx)
+
+	left, err := admin.RecoveryCodesLeft()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't count recovery codes of admin id: %v \n\t %s", admin.Id, err)
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": map[string]interface{}{
+		"enabled":           admin.TotpEnabled,
+		"required":          config.Config.AdminTotpRequired,
+		"recoveryCodesLeft": left,
+	}})
+}
+
+// SetupTotp starts the enrolment, the provisioning URI is shown as a QR code.
+func SetupTotp(ctx iris.Context) {
+	admin := CurrentAdmin(ctx)
+
+	secret, uri, err := admin.SetupTotp()
+	if !totpError(ctx, admin, err) {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": map[string]string{"secret": secret, "uri": uri}})
+}
+
+// EnableTotp finishes the enrolment with a code of the authenticator and returns the recovery codes.
+func EnableTotp(ctx iris.Context) {
+	admin := CurrentAdmin(ctx)
+
+	codes, err := admin.EnableTotp(readCode(ctx))
+	if !totpError(ctx, admin, err) {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": map[string]interface{}{"recoveryCodes": codes}})
+}
+
+// DisableTotp turns two-factor authentication off, it needs a current code.
+func DisableTotp(ctx iris.Context) {
+	admin := CurrentAdmin(ctx)
+
+	if config.Config.AdminTotpRequired {
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"totp": "Two-factor authentication is required"}})
+		return
+	}
+
+	if !totpError(ctx, admin, admin.VerifySecondFactor(readCode(ctx))) {
+		return
+	}
+	if !totpError(ctx, admin, admin.DisableTotp()) {
+		return
+	}
+
+	ctx.JSON(map[string]bool{"success": true})
+}
+
+// NewRecoveryCodes replaces the recovery codes, it needs a current code.
+func NewRecoveryCodes(ctx iris.Context) {
+	admin := CurrentAdmin(ctx)
+
+	if !admin.TotpEnabled {
+		totpError(ctx, admin, model.ErrTotpNotStarted)
+		return
+	}
+	if !totpError(ctx, admin, admin.VerifySecondFactor(readCode(ctx))) {
+		return
+	}
+
+	codes, err := admin.NewRecoveryCodes()
+	if !totpError(ctx, admin, err) {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": map[string]interface{}{"recoveryCodes": codes}})
+}
+
+// ResetAdminTotp turns two-factor authentication off for an admin who lost the authenticator and recovery codes.
+func ResetAdminTotp(ctx iris.Context) {
+	admin, ok := routeAdmin(ctx)
+	if !ok {
+		return
+	}
+
+	if !totpError(ctx, admin, admin.DisableTotp()) {
+		return
+	}
+	if !totpError(ctx, admin, admin.RevokeSessions()) {
+		return
+	}
+
+	ctx.JSON(map[string]interface{}{"data": admin})
+}
+
+func readCode(ctx iris.Context) string {
+	var body struct {
+		Code string `json:"code"`
+	}
+	ctx.ReadJSON(&body)
+
+	return body.Code
+}
+
+// totpError writes the response of a failed two-factor operation, it returns true if there is no error.
+func totpError(ctx iris.Context, admin *model.Admin, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case model.ErrInvalidCode:
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"code": err.Error()}})
+	case model.ErrTotpEnabled, model.ErrTotpNotStarted:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"totp": err.Error()}})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't change two-factor authentication of admin id: %v \n\t %s", admin.Id, err)
+	}
+
+	return false
+}
@@ -8,13 +8,17 @@ import (
 	"github.com/kataras/iris"
 	"github.com/go-ozzo/ozzo-validation"
 	"github.com/dchest/captcha"
+	"github.com/go-ozzo/ozzo-validation/is"
 	"database/sql"
+	"net/http"
+	"time"
 
 	"../config"
 	"../utils"
 	"../model"
 	"../model/validation_rules"
 	"../email"
+	"../imaging"
 )
 
 func WhitelistRequest(ctx iris.Context) {
@@ -31,11 +35,9 @@ func WhitelistRequest(ctx iris.Context) {
 		Country:     ctx.FormValue("country"),
 		Citizenship: ctx.FormValue("citizenship"),
 		Birthday:    birthday,
+		Language:    email.Language(ctx.FormValue("language")),
 	}
 
-	// Get the file from the request.
-	passportFile, passportInfo, passportErr := ctx.FormFile("passport")
-
 	var errs = validation.Errors{}
 
 	if e, ok := whitelist.Validate().(validation.Errors); ok {
@@ -43,12 +45,18 @@ func WhitelistRequest(ctx iris.Context) {
 			errs[strings.ToLower(name[:1])+name[1:]] = value
 		}
 	}
-	if passportErr != nil {
-		errs["passport"] = errors.New(fmt.Sprintf("Filesize is very large. Allowed up to %v Mb", config.Config.MaxFileUploadSizeMb))
-	} else
-	if passportInfo.Filename == "" {
-		errs["passport"] = errors.New("Add a file of your passport")
+
+	// Get the files from the request.
+	uploads := map[string]*imaging.Upload{}
+	for _, field := range []string{"passport", "selfie", "residential-photo", "statement-photo"} {
+		upload, err := readDocument(ctx, field, field == "passport")
+		if err != nil {
+			errs[field] = err
+		} else if upload != nil {
+			uploads[field] = upload
+		}
 	}
+
 	if !captcha.VerifyString(ctx.FormValue("captchaId"), ctx.FormValue("captchaSolution")) {
 		errs["captchaSolution"] = errors.New("Captcha check has been failed")
 	}
@@ -72,7 +80,7 @@ func WhitelistRequest(ctx iris.Context) {
 	}
 
 	photo := &model.Photo{}
-	if err := photo.StoreFile(passportFile, passportInfo); err != nil {
+	if err := photo.StoreFile(uploads["passport"]); err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		println("Can't save passport!\n\t" + err.Error())
 		return
@@ -80,11 +88,9 @@ func WhitelistRequest(ctx iris.Context) {
 
 	whitelist.PassportId = photo.Id
 
-	// Get selfie file from the request.
-	selfieFile, selfieInfo, selfieErr := ctx.FormFile("selfie")
-	if selfieErr == nil {
+	if upload, ok := uploads["selfie"]; ok {
 		selfie := &model.Photo{}
-		if err := selfie.StoreFile(selfieFile, selfieInfo); err != nil {
+		if err := selfie.StoreFile(upload); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			println("Can't save selfie!\n\t" + err.Error())
 			return
@@ -93,11 +99,9 @@ func WhitelistRequest(ctx iris.Context) {
 		whitelist.SelfieId = sql.NullInt64{Int64: selfie.Id, Valid: true}
 	}
 
-	// Get ResidentialPhoto file from the request.
-	ResidentialPhotoFile, ResidentialPhotoInfo, ResidentialPhotoErr := ctx.FormFile("residential-photo")
-	if ResidentialPhotoErr == nil {
+	if upload, ok := uploads["residential-photo"]; ok {
 		ResidentialPhoto := &model.Photo{}
-		if err := ResidentialPhoto.StoreFile(ResidentialPhotoFile, ResidentialPhotoInfo); err != nil {
+		if err := ResidentialPhoto.StoreFile(upload); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			println("Can't save ResidentialPhoto!\n\t" + err.Error())
 			return
@@ -106,11 +110,9 @@ func WhitelistRequest(ctx iris.Context) {
 		whitelist.ResidentialPhotoId = sql.NullInt64{Int64: ResidentialPhoto.Id, Valid: true}
 	}
 
-	// Get StatementPhoto file from the request.
-	StatementPhotoFile, StatementPhotoInfo, StatementPhotoErr := ctx.FormFile("statement-photo")
-	if StatementPhotoErr == nil {
+	if upload, ok := uploads["statement-photo"]; ok {
 		StatementPhoto := &model.Photo{}
-		if err := StatementPhoto.StoreFile(StatementPhotoFile, StatementPhotoInfo); err != nil {
+		if err := StatementPhoto.StoreFile(upload); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			println("Can't save StatementPhoto!\n\t" + err.Error())
 			return
@@ -119,18 +121,36 @@ func WhitelistRequest(ctx iris.Context) {
 		whitelist.StatementPhotoId = sql.NullInt64{Int64: StatementPhoto.Id, Valid: true}
 	}
 
-	token, err := whitelist.StoreData()
-	if err != nil {
+	if _, err := whitelist.StoreData(email.ConfirmEmail); err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		println("Can't insert whitelist " + err.Error())
 		return
 	}
 
-	email.ConfirmEmail(whitelist.Email, token)
-
 	ctx.JSON(map[string]bool{"success": true})
 }
 
+// readDocument reads and verifies an uploaded file, returns nil if an optional file is absent.
+func readDocument(ctx iris.Context, field string, required bool) (*imaging.Upload, error) {
+	file, info, err := ctx.FormFile(field)
+	if err == http.ErrMissingFile {
+		if required {
+			return nil, errors.New("Add a file of your " + field)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Filesize is very large. Allowed up to %v Mb", config.Config.MaxFileUploadSizeMb))
+	}
+	defer file.Close()
+
+	if info.Filename == "" {
+		return nil, errors.New("Add a file of your " + field)
+	}
+
+	return imaging.Read(file, info.Filename)
+}
+
 func WhitelistConfirmEmail(ctx iris.Context) {
 	token := ctx.FormValue("token")
 
@@ -141,7 +161,7 @@ func WhitelistConfirmEmail(ctx iris.Context) {
 	}
 
 	whitelistToken := &model.WhitelistToken{}
-	has, err := whitelistToken.GetValidToken(token)
+	has, err := whitelistToken.GetValidToken(token, model.TOKEN_CONFIRM_EMAIL)
 	if err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		println("Token database search error. " + err.Error())
@@ -153,7 +173,7 @@ func WhitelistConfirmEmail(ctx iris.Context) {
 		return
 	}
 
-	whitelist, err := whitelistToken.TokenConfirmed()
+	whitelist, err := whitelistToken.TokenConfirmed(ctx.RemoteAddr())
 	if err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		println("Can't confirm token in database. " + err.Error())
@@ -163,3 +183,227 @@ func WhitelistConfirmEmail(ctx iris.Context) {
 	ctx.ViewData("email", whitelist.Email)
 	ctx.View("email-confirmed.html")
 }
+
+// per hour
+var (
+	resendPerEmail      = rateLimit{"resend-email", limit(config.Config.ResendConfirmationPerEmail, 3)}
+	resendPerIp         = rateLimit{"resend-ip", limit(config.Config.ResendConfirmationPerIp, 10)}
+	dataRequestPerEmail = rateLimit{"data-request-email", limit(config.Config.DataRequestPerEmail, 3)}
+	dataRequestPerIp    = rateLimit{"data-request-ip", limit(config.Config.DataRequestPerIp, 10)}
+)
+
+func limit(configured int, fallback int) int {
+	if configured > 0 {
+		return configured
+	}
+
+	return fallback
+}
+
+// rateLimit counts requests in the database, so every instance of the application shares the limit
+type rateLimit struct {
+	scope string
+	limit int
+}
+
+func (l rateLimit) allow(subject string) (bool, error) {
+	return model.AllowEvent(l.scope, subject, l.limit, time.Hour)
+}
+
+// WhitelistResendConfirmation issues a new confirmation link for an unconfirmed application,
+// the response is the same whether the email is registered or not.
+func WhitelistResendConfirmation(ctx iris.Context) {
+	whitelist, has, ok := emailRequest(ctx, resendPerIp, resendPerEmail)
+	if !ok {
+		return
+	}
+
+	if has && whitelist.VerificationStage == model.STAGE_EMAIL_NOT_CONFIRMED {
+		if err := whitelist.ResendConfirmation(email.ConfirmEmail); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			fmt.Printf("Can't resend confirmation whitelistId: %v \n\t %s", whitelist.Id, err)
+			return
+		}
+	}
+
+	ctx.JSON(map[string]bool{"success": true})
+}
+
+// emailRequest checks the captcha and limits of a request by email and looks the application up,
+// callers answer the same whether it exists or not.
+func emailRequest(ctx iris.Context, perIp, perEmail rateLimit) (whitelist *model.Whitelist, has bool, ok bool) {
+	address := strings.TrimSpace(ctx.FormValue("email"))
+
+	var errs = validation.Errors{}
+	if err := validation.Validate(address, validation.Required, is.Email); err != nil {
+		errs["email"] = err
+	}
+	if !captcha.VerifyString(ctx.FormValue("captchaId"), ctx.FormValue("captchaSolution")) {
+		errs["captchaSolution"] = errors.New("Captcha check has been failed")
+	}
+	if len(errs) > 0 {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.JSON(map[string]interface{}{"errors": errs})
+		return nil, false, false
+	}
+
+	allowed, err := perIp.allow(ctx.RemoteAddr())
+	if err == nil && allowed {
+		allowed, err = perEmail.allow(strings.ToLower(address))
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't check the request limit.\n\t" + err.Error())
+		return nil, false, false
+	}
+	if !allowed {
+		ctx.StatusCode(iris.StatusTooManyRequests)
+		ctx.JSON(map[string]interface{}{"errors": map[string]string{"email": "Too many requests, please try again later."}})
+		return nil, false, false
+	}
+
+	whitelist, has, err = model.GetWhitelistByEmail(address)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't find whitelist record in database.\n\t" + err.Error())
+		return nil, false, false
+	}
+
+	return whitelist, has, true
+}
+
+// WhitelistReplyForm shows the reviewer question to the applicant.
+func WhitelistReplyForm(ctx iris.Context) {
+	whitelistToken, ok := replyToken(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.ViewData("token", whitelistToken.Token)
+	ctx.ViewData("errors", map[string]string{})
+	ctx.View("question-reply.html")
+}
+
+// WhitelistReply stores the applicant answer and documents, the application returns to review.
+func WhitelistReply(ctx iris.Context) {
+	whitelistToken, ok := replyToken(ctx)
+	if !ok {
+		return
+	}
+
+	text := strings.TrimSpace(ctx.FormValue("reply"))
+
+	var errs = map[string]string{}
+	if err := validation.Validate(text, validation.Required, validation.Length(1, 2000)); err != nil {
+		errs["reply"] = err.Error()
+	}
+
+	uploads := map[string]*imaging.Upload{}
+	for _, field := range []string{"selfie", "residential-photo", "statement-photo"} {
+		upload, err := readDocument(ctx, field, false)
+		if err != nil {
+			errs[field] = err.Error()
+		} else if upload != nil {
+			uploads[field] = upload
+		}
+	}
+
+	if len(errs) > 0 {
+		ctx.StatusCode(iris.StatusUnprocessableEntity)
+		ctx.ViewData("token", whitelistToken.Token)
+		ctx.ViewData("reply", text)
+		ctx.ViewData("errors", errs)
+		ctx.View("question-reply.html")
+		return
+	}
+
+	// documents of a reply that can't be stored are deleted again
+	var stored []*model.Photo
+	deleteStored := func() {
+		for _, photo := range stored {
+			if err := photo.Delete(); err != nil {
+				fmt.Printf("Can't delete photoId: %v \n\t %s\n", photo.Id, err)
+			}
+		}
+	}
+
+	reply := model.QuestionReply{Text: text}
+	for field, id := range map[string]*sql.NullInt64{
+		"selfie":            &reply.SelfieId,
+		"residential-photo": &reply.ResidentialPhotoId,
+		"statement-photo":   &reply.StatementPhotoId,
+	} {
+		upload, ok := uploads[field]
+		if !ok {
+			continue
+		}
+
+		photo := &model.Photo{}
+		if err := photo.StoreFile(upload); err != nil {
+			deleteStored()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			println("Can't save " + field + "!\n\t" + err.Error())
+			return
+		}
+		stored = append(stored, photo)
+		*id = sql.NullInt64{Int64: photo.Id, Valid: true}
+	}
+
+	if _, err := whitelistToken.QuestionReplied(reply, ctx.RemoteAddr()); err != nil {
+		deleteStored()
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't store question reply. " + err.Error())
+		return
+	}
+
+	ctx.View("question-replied.html")
+}
+
+// replyToken renders an error page unless the request has a valid question reply token
+func replyToken(ctx iris.Context) (*model.WhitelistToken, bool) {
+	token := ctx.FormValue("token")
+
+	if !validation_rules.TokenRegex.MatchString(token) {
+		ctx.ViewData("message", "Invalid token data")
+		ctx.View("email-confirmation-error.html")
+		return nil, false
+	}
+
+	whitelistToken := &model.WhitelistToken{}
+	has, err := whitelistToken.GetValidToken(token, model.TOKEN_QUESTION_REPLY)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Token database search error. " + err.Error())
+		return nil, false
+	}
+	if !has {
+		ctx.ViewData("message", "This link has expired or you have replied already.")
+		ctx.View("email-confirmation-error.html")
+		return nil, false
+	}
+
+	// the question may have been decided in the meantime
+	whitelist, has, err := model.GetWhitelist(whitelistToken.WhitelistId)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find whitelist id: %v \n\t %v", whitelistToken.WhitelistId, err)
+		return nil, false
+	}
+	if !has || whitelist.VerificationStage != model.STAGE_QUESTION {
+		ctx.ViewData("message", "This link has expired or you have replied already.")
+		ctx.View("email-confirmation-error.html")
+		return nil, false
+	}
+
+	question, has, err := model.GetLastQuestion(whitelistToken.WhitelistId)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Can't find question. " + err.Error())
+		return nil, false
+	}
+	if has {
+		ctx.ViewData("question", model.ApplicantMessage(config.Config.QuestionReasons, question.ReasonCode, question.Note, question.ShareWithApplicant))
+	}
+
+	return whitelistToken, true
+}
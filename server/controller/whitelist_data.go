@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris"
+
+	"../email"
+	"../model"
+	"../model/validation_rules"
+)
+
+// WhitelistDataRequest emails the applicant a link to download their data,
+// the response is the same whether the email is registered or not.
+func WhitelistDataRequest(ctx iris.Context) {
+	whitelist, has, ok := emailRequest(ctx, dataRequestPerIp, dataRequestPerEmail)
+	if !ok {
+		return
+	}
+
+	if has {
+		if err := whitelist.SendToken(model.TOKEN_DATA_EXPORT, 48*time.Hour, email.DataExportEmail); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			fmt.Printf("Can't send data export link whitelistId: %v \n\t %s", whitelist.Id, err)
+			return
+		}
+	}
+
+	ctx.JSON(map[string]bool{"success": true})
+}
+
+// WhitelistData streams the data export, the link can be used until it expires.
+func WhitelistData(ctx iris.Context) {
+	token := ctx.FormValue("token")
+
+	if !validation_rules.TokenRegex.MatchString(token) {
+		ctx.ViewData("message", "Invalid token data")
+		ctx.View("email-confirmation-error.html")
+		return
+	}
+
+	whitelistToken := &model.WhitelistToken{}
+	has, err := whitelistToken.GetValidToken(token, model.TOKEN_DATA_EXPORT)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		println("Token database search error. " + err.Error())
+		return
+	}
+	if !has {
+		ctx.ViewData("message", "This link has expired, please request a new one.")
+		ctx.View("email-confirmation-error.html")
+		return
+	}
+
+	whitelist, has, err := model.GetWhitelist(whitelistToken.WhitelistId)
+	if err != nil || !has {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		fmt.Printf("Can't find whitelist id: %v \n\t %v", whitelistToken.WhitelistId, err)
+		return
+	}
+
+	ctx.ContentType("application/zip")
+	ctx.Header("Content-Disposition", "attachment; filename=\"whitelist-"+strconv.FormatInt(whitelist.Id, 10)+".zip\"")
+	ctx.Header("Cache-Control", "no-store")
+
+	// the status is sent with the first bytes, errors can only be logged
+	if err := whitelist.WriteArchive(ctx.ResponseWriter(), true); err != nil {
+		fmt.Printf("Can't export whitelist id: %v \n\t %s", whitelist.Id, err)
+	}
+}
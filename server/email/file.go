@@ -0,0 +1,41 @@
+package email
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File writes every message as an .eml file, for development and tests.
+type File struct {
+	Root string
+}
+
+func NewFile(root string) *File {
+	if root == "" {
+		root = "./mail"
+	}
+
+	return &File{Root: root}
+}
+
+func (f *File) Send(m Message) (string, error) {
+	id := NewMessageId(m.From)
+	data, err := m.Bytes(id)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(f.Root, 0700); err != nil {
+		return "", err
+	}
+
+	name := time.Now().Format("20060102-150405") + "-" + strings.Trim(id, "<>") + ".eml"
+	if err := ioutil.WriteFile(filepath.Join(f.Root, name), data, 0600); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
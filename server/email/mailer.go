@@ -0,0 +1,133 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"../config"
+	"../utils"
+)
+
+// Message is a text and/or html email to a single recipient.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string // plain text body
+	HTML    string // html body
+	ReplyTo string
+}
+
+// Mailer delivers messages and returns the id assigned by the transport.
+type Mailer interface {
+	Send(m Message) (id string, err error)
+}
+
+var Mail Mailer
+
+func Init() (mailer Mailer, err error) {
+	switch config.Config.MailDriver {
+	case "ses", "":
+		mailer, err = NewSES(config.Config.AwsKey, config.Config.AwsSecret, config.Config.AwsRegion)
+	case "smtp":
+		mailer = NewSMTP(SMTPOptions{
+			Host:     config.Config.SmtpHost,
+			Port:     config.Config.SmtpPort,
+			User:     config.Config.SmtpUser,
+			Password: config.Config.SmtpPassword,
+		})
+	case "file":
+		mailer = NewFile(config.Config.MailPath)
+	default:
+		return nil, errors.New("Unknown mail driver: " + config.Config.MailDriver)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	path := config.Config.EmailTemplatePath
+	if path == "" {
+		path = "./templates/email"
+	}
+	if Templates, err = LoadTemplates(path); err != nil {
+		return nil, errors.New("Can't load email templates: " + err.Error())
+	}
+	if _, ok := Templates[DefaultLanguage()]; !ok {
+		return nil, errors.New("Can't find email templates for " + DefaultLanguage())
+	}
+
+	Mail = mailer
+
+	return Mail, nil
+}
+
+// NewMessageId returns a unique Message-ID in the domain of the sender.
+func NewMessageId(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at != -1 {
+		domain = strings.TrimRight(from[at+1:], ">")
+	}
+
+	return "<" + utils.RandomString(32) + "@" + domain + ">"
+}
+
+// Bytes renders the message in RFC 5322 format as multipart/alternative.
+func (m Message) Bytes(messageId string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := multipart.NewWriter(&buf)
+
+	header := []string{
+		"From: " + m.From,
+		"To: " + m.To,
+		"Subject: " + mime.QEncoding.Encode("UTF-8", m.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageId,
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + w.Boundary(),
+	}
+	if m.ReplyTo != "" {
+		header = append(header, "Reply-To: "+m.ReplyTo)
+	}
+	buf.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain", m.Text},
+		{"text/html", m.HTML},
+	}
+	for _, part := range parts {
+		if part.body == "" {
+			continue
+		}
+
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("Can't render message: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
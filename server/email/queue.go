@@ -0,0 +1,83 @@
+package email
+
+import (
+	"fmt"
+	"time"
+
+	"../config"
+	"../model"
+)
+
+// Lease is how long a claimed email is hidden from other workers.
+const Lease = 5 * time.Minute
+
+// Backoff returns the delay before the next attempt: 1m, 2m, 4m ... up to 6h.
+func Backoff(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+
+	return delay
+}
+
+// Deliver sends due emails from the outbox, failed attempts are retried with backoff.
+func Deliver() (sent int, err error) {
+	for {
+		emails, err := model.ClaimEmails(50, Lease)
+		if err != nil || len(emails) == 0 {
+			return sent, err
+		}
+
+		for i := range emails {
+			ok, err := send(&emails[i])
+			if err != nil {
+				return sent, err
+			}
+			if ok {
+				sent++
+			}
+		}
+	}
+}
+
+// DeliverEmail sends one due email right away, unless a worker has claimed it.
+func DeliverEmail(e *model.WhitelistEmail) (sent bool, err error) {
+	claimed, err := e.Claim(Lease)
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	return send(e)
+}
+
+// send tries a claimed email once and records the result.
+func send(e *model.WhitelistEmail) (bool, error) {
+	maxAttempts := config.Config.MailMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 8
+	}
+
+	id, sendErr := Mail.Send(Message{
+		From:    e.Sender,
+		To:      e.Recipient,
+		ReplyTo: e.ReplyTo,
+		Subject: e.Subject,
+		Text:    e.Text,
+		HTML:    e.Html,
+	})
+	if sendErr == nil {
+		return true, e.Delivered(id)
+	}
+
+	fmt.Printf("Can't send email id: %v \n\t %s\n", e.Id, sendErr)
+
+	var next time.Time
+	if e.Attempts+1 < maxAttempts {
+		next = time.Now().Add(Backoff(e.Attempts + 1))
+	}
+	return false, e.Undelivered(sendErr, next)
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+// SES sends messages through Amazon Simple Email Service, the session is shared by all messages.
+type SES struct {
+	client *ses.SES
+}
+
+// NewSES uses the given credentials, or the default AWS credential chain when key is empty.
+func NewSES(key, secret, region string) (*SES, error) {
+	cfg := &aws.Config{
+		Region: aws.String(region),
+	}
+	if key != "" {
+		cfg.Credentials = credentials.NewStaticCredentials(key, secret, "")
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SES{client: ses.New(sess)}, nil
+}
+
+func (s *SES) Send(m Message) (string, error) {
+	body := &ses.Body{}
+	if m.Text != "" || m.HTML == "" {
+		body.Text = &ses.Content{
+			Data:    aws.String(m.Text),
+			Charset: aws.String("UTF-8"),
+		}
+	}
+	if m.HTML != "" {
+		body.Html = &ses.Content{
+			Data:    aws.String(m.HTML),
+			Charset: aws.String("UTF-8"),
+		}
+	}
+
+	params := &ses.SendEmailInput{
+		Destination: &ses.Destination{
+			ToAddresses: []*string{aws.String(m.To)},
+		},
+		Message: &ses.Message{
+			Body: body,
+			Subject: &ses.Content{
+				Data:    aws.String(m.Subject),
+				Charset: aws.String("UTF-8"),
+			},
+		},
+		Source: aws.String(m.From),
+	}
+	if m.ReplyTo != "" {
+		params.ReplyToAddresses = []*string{aws.String(m.ReplyTo)}
+	}
+
+	resp, err := s.client.SendEmail(params)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(resp.MessageId), nil
+}
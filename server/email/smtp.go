@@ -0,0 +1,57 @@
+package email
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+type SMTPOptions struct {
+	Host     string
+	Port     int    // 587 when empty
+	User     string // no authentication when empty
+	Password string
+}
+
+// SMTP sends messages to a mail relay, STARTTLS is used when the server offers it.
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+}
+
+func NewSMTP(opts SMTPOptions) *SMTP {
+	if opts.Port == 0 {
+		opts.Port = 587
+	}
+
+	s := &SMTP{addr: net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))}
+	if opts.User != "" {
+		s.auth = smtp.PlainAuth("", opts.User, opts.Password, opts.Host)
+	}
+
+	return s
+}
+
+func (s *SMTP) Send(m Message) (string, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", err
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return "", err
+	}
+
+	id := NewMessageId(from.Address)
+	data, err := m.Bytes(id)
+	if err != nil {
+		return "", err
+	}
+
+	if err := smtp.SendMail(s.addr, s.auth, from.Address, []string{to.Address}, data); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
@@ -0,0 +1,156 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"../config"
+	"../model"
+)
+
+// Template of one email in one language, loaded from <dir>/<language>/<name>.subject.txt, .txt and .html.
+type Template struct {
+	Subject *texttemplate.Template
+	Text    *texttemplate.Template // optional
+	HTML    *htmltemplate.Template // optional
+}
+
+// TemplateData is passed to every email template.
+type TemplateData struct {
+	Whitelist    *model.Whitelist
+	Link         string   // confirmation or reply link
+	Message      string   // decision reason and note shared with the applicant
+	MessageLines []string // Message split for html templates
+	PublicUrl    string
+	SupportEmail string
+}
+
+// Templates by language and email name, e.g. Templates["en"]["confirm_email"].
+var Templates map[string]map[string]*Template
+
+// LoadTemplates reads a directory per language.
+func LoadTemplates(dir string) (map[string]map[string]*Template, error) {
+	languages, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := map[string]map[string]*Template{}
+	for _, language := range languages {
+		if !language.IsDir() {
+			continue
+		}
+
+		subjects, err := filepath.Glob(filepath.Join(dir, language.Name(), "*.subject.txt"))
+		if err != nil {
+			return nil, err
+		}
+
+		templates[language.Name()] = map[string]*Template{}
+		for _, subject := range subjects {
+			base := strings.TrimSuffix(subject, ".subject.txt")
+			t := &Template{}
+
+			if t.Subject, err = texttemplate.ParseFiles(subject); err != nil {
+				return nil, err
+			}
+			if _, err := os.Stat(base + ".txt"); err == nil {
+				if t.Text, err = texttemplate.ParseFiles(base + ".txt"); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := os.Stat(base + ".html"); err == nil {
+				if t.HTML, err = htmltemplate.ParseFiles(base + ".html"); err != nil {
+					return nil, err
+				}
+			}
+			if t.Text == nil && t.HTML == nil {
+				return nil, errors.New("Email template has no body: " + base)
+			}
+
+			templates[language.Name()][filepath.Base(base)] = t
+		}
+	}
+
+	return templates, nil
+}
+
+// Language returns a loaded language for the submitted one, e.g. "de-DE" gives "de", or the default language.
+func Language(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(language, "-_"); i != -1 {
+		language = language[:i]
+	}
+
+	if _, ok := Templates[language]; ok {
+		return language
+	}
+
+	return DefaultLanguage()
+}
+
+func DefaultLanguage() string {
+	if config.Config.DefaultLanguage == "" {
+		return "en"
+	}
+
+	return config.Config.DefaultLanguage
+}
+
+// Render builds the email in the given language, falling back to the default language.
+func Render(language, name string, data TemplateData) (Message, error) {
+	var m Message
+
+	t, ok := Templates[Language(language)][name]
+	if !ok {
+		if t, ok = Templates[DefaultLanguage()][name]; !ok {
+			return m, errors.New("Can't find email template " + name)
+		}
+	}
+
+	data.PublicUrl = PublicURL("")
+	data.SupportEmail = config.Config.SupportEmail
+	if data.Message != "" {
+		data.MessageLines = strings.Split(data.Message, "\n")
+	}
+
+	var buf bytes.Buffer
+	if err := t.Subject.Execute(&buf, data); err != nil {
+		return m, err
+	}
+	m.Subject = strings.TrimSpace(buf.String())
+
+	if t.Text != nil {
+		buf.Reset()
+		if err := t.Text.Execute(&buf, data); err != nil {
+			return m, err
+		}
+		m.Text = buf.String()
+	}
+
+	if t.HTML != nil {
+		buf.Reset()
+		if err := t.HTML.Execute(&buf, data); err != nil {
+			return m, err
+		}
+		m.HTML = buf.String()
+	}
+
+	return m, nil
+}
+
+// PublicURL returns an absolute link to the application, e.g. PublicURL("/whitelist/reply?token=...").
+func PublicURL(path string) string {
+	base := config.Config.PublicUrl
+	if base == "" {
+		base = "https://mdl.life"
+	}
+
+	return strings.TrimRight(base, "/") + path
+}
@@ -1,27 +1,117 @@
 package email
 
 import (
-	"../ses"
+	"time"
+	"strconv"
+
 	"../config"
+	"../model"
 )
 
-func ConfirmEmail(to string, token string) {
-	emailData := ses.Email{
-	To:   to,
-	From: config.Config.NoReplyEmail,
-	Text: "Your whitelist submission is well received.\n\n" +
-	"To finish the whitelist application process please confirm your email by following the link/n" +
-	"https://mdl.life/whitelist/confirm_email?token=" + token + "\n\n" +
-	"The instructions of how to purchase the MDL Tokens to be send soon is confirmation that you have passed the whitelist.\n\n" +
-	"For inquiries and support please contact support@mdl.life",
-	HTML: "<h3 style=\"color:purple;\">Your whitelist submission is well received.</h3><br>" +
-	"To finish the whitelist application process please confirm your email by clicking the link<br>" +
-	"<a href=\"https://mdl.life/whitelist/confirm_email?token=" + token + "\">" + "https://mdl.life/whitelist/confirm_email?token=" + token + "</a><br><br>" +
-	"The instructions of how to purchase the MDL Tokens to be send soon is confirmation that you have passed the whitelist.<br><br>" +
-	"For inquiries and support please contact <a href=\"mailto:support@mdl.life\">support@mdl.life</a>",
-	Subject: "MDL Talent Hub: Whitelist application received",
-	ReplyTo: config.Config.ReplyEmail,
-	}
-
-	ses.SendEmail(emailData)
+// send notifications on stage changes
+func init() {
+	model.AddStageHook(model.STAGE_ACCEPTED, AcceptedEmail)
+	model.AddStageHook(model.STAGE_DECLINED, DeclinedEmail)
+	model.AddStageHook(model.STAGE_QUESTION, QuestionEmail)
+}
+
+// ConfirmEmail builds the confirmation email, it is queued together with the application.
+func ConfirmEmail(w *model.Whitelist, token string) (*model.WhitelistEmail, error) {
+	return render(w, "confirm_email", TemplateData{Link: PublicURL("/whitelist/confirm_email?token=" + token)})
+}
+
+// DataExportEmail sends the link to download the applicant's data.
+func DataExportEmail(w *model.Whitelist, token string) (*model.WhitelistEmail, error) {
+	return render(w, "data_export", TemplateData{Link: PublicURL("/whitelist/data?token=" + token)})
+}
+
+// AcceptedEmail tells the applicant they passed the whitelist.
+func AcceptedEmail(w *model.Whitelist, change model.StageChange) {
+	message := model.ApplicantMessage(nil, "", change.Note, change.ShareWithApplicant)
+
+	e, err := render(w, "accepted", TemplateData{Message: message})
+	if err != nil {
+		println("Can't render accepted email " + err.Error())
+		return
+	}
+
+	enqueue(e, change.SuppressEmail)
+}
+
+// DeclinedEmail tells the applicant why the application was declined.
+func DeclinedEmail(w *model.Whitelist, change model.StageChange) {
+	message := model.ApplicantMessage(config.Config.DeclineReasons, change.Reason, change.Note, change.ShareWithApplicant)
+
+	e, err := render(w, "declined", TemplateData{Message: message})
+	if err != nil {
+		println("Can't render declined email " + err.Error())
+		return
+	}
+
+	enqueue(e, change.SuppressEmail)
+}
+
+// QuestionEmail sends the reviewer question with a link to reply and upload documents.
+func QuestionEmail(w *model.Whitelist, change model.StageChange) {
+	data := TemplateData{
+		Message: model.ApplicantMessage(config.Config.QuestionReasons, change.Reason, change.Note, change.ShareWithApplicant),
+	}
+
+	// the reply link is only created for an email that is sent
+	if !change.SuppressEmail {
+		token, err := w.NewToken(model.TOKEN_QUESTION_REPLY, 14*24*time.Hour)
+		if err != nil {
+			println("Can't create question reply token " + err.Error())
+			return
+		}
+		data.Link = PublicURL("/whitelist/reply?token=" + token)
+	}
+
+	e, err := render(w, "question", data)
+	if err != nil {
+		println("Can't render question email " + err.Error())
+		return
+	}
+
+	enqueue(e, change.SuppressEmail)
+}
+
+// render builds the email in the language of the applicant.
+func render(w *model.Whitelist, kind string, data TemplateData) (*model.WhitelistEmail, error) {
+	data.Whitelist = w
+
+	m, err := Render(w.Language, kind, data)
+	if err != nil {
+		return nil, err
+	}
+
+	m.To = w.Email
+	m.From = config.Config.NoReplyEmail
+	m.ReplyTo = config.Config.ReplyEmail
+
+	return newEmail(w, kind, m), nil
+}
+
+func newEmail(w *model.Whitelist, kind string, m Message) *model.WhitelistEmail {
+	return &model.WhitelistEmail{
+		WhitelistId: w.Id,
+		Kind:        kind,
+		Sender:      m.From,
+		Recipient:   m.To,
+		ReplyTo:     m.ReplyTo,
+		Subject:     m.Subject,
+		Text:        m.Text,
+		Html:        m.HTML,
+	}
+}
+
+// enqueue hands the email to the delivery worker, a suppressed one is only recorded.
+func enqueue(e *model.WhitelistEmail, suppress bool) {
+	if suppress {
+		e.Status = model.EMAIL_SUPPRESSED
+	}
+
+	if err := e.Enqueue(); err != nil {
+		println("Can't queue " + e.Kind + " email to whitelistId: " + strconv.FormatInt(e.WhitelistId, 10) + " " + err.Error())
+	}
 }
@@ -0,0 +1,223 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"../config"
+	"../utils"
+)
+
+// Envelope encryption: every blob is sealed with its own random data key,
+// the data key is wrapped by a master key and stored next to the record.
+// Rotating a master key only re-wraps data keys, blobs stay untouched.
+
+const dataKeySize = 32
+
+var ErrUnknownKey = errors.New("master key is not configured")
+
+// MasterKey wraps and unwraps data keys.
+type MasterKey struct {
+	Id   string
+	aead cipher.AEAD
+}
+
+func NewMasterKey(key []byte) (*MasterKey, error) {
+	if len(key) != 32 {
+		return nil, errors.New("master key must be 32 bytes long")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(key)
+
+	return &MasterKey{Id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+// Keyring holds the current master key used for new data keys and older ones kept for reading.
+type Keyring struct {
+	Current *MasterKey
+	keys    map[string]*MasterKey
+}
+
+func NewKeyring(current *MasterKey, old ...*MasterKey) *Keyring {
+	k := &Keyring{Current: current, keys: map[string]*MasterKey{}}
+	for _, key := range append(old, current) {
+		if key != nil {
+			k.keys[key.Id] = key
+		}
+	}
+
+	return k
+}
+
+// Enabled reports whether new blobs are encrypted.
+func (k *Keyring) Enabled() bool {
+	return k != nil && k.Current != nil
+}
+
+// Seal encrypts data with a new data key and returns the wrapped data key.
+func (k *Keyring) Seal(data []byte) (sealed []byte, wrappedKey string, keyId string, err error) {
+	if !k.Enabled() {
+		return nil, "", "", ErrUnknownKey
+	}
+
+	dataKey := utils.SecureRandomBytes(dataKeySize)
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return seal(aead, data, nil), k.Current.wrap(dataKey), k.Current.Id, nil
+}
+
+// SealWithKey encrypts data with an existing data key, e.g. a thumbnail next to its original.
+func (k *Keyring) SealWithKey(data []byte, wrappedKey string, keyId string) ([]byte, error) {
+	dataKey, err := k.unwrap(wrappedKey, keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(aead, data, nil), nil
+}
+
+// Open decrypts data sealed by Seal.
+func (k *Keyring) Open(sealed []byte, wrappedKey string, keyId string) ([]byte, error) {
+	dataKey, err := k.unwrap(wrappedKey, keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, sealed, nil)
+}
+
+// Rewrap wraps a data key with the current master key.
+func (k *Keyring) Rewrap(wrappedKey string, keyId string) (string, string, error) {
+	if !k.Enabled() {
+		return "", "", ErrUnknownKey
+	}
+
+	dataKey, err := k.unwrap(wrappedKey, keyId)
+	if err != nil {
+		return "", "", err
+	}
+
+	return k.Current.wrap(dataKey), k.Current.Id, nil
+}
+
+func (k *Keyring) unwrap(wrappedKey string, keyId string) ([]byte, error) {
+	if k == nil {
+		return nil, ErrUnknownKey
+	}
+
+	key, ok := k.keys[keyId]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key.unwrap(wrappedKey)
+}
+
+func (m *MasterKey) wrap(dataKey []byte) string {
+	return base64.StdEncoding.EncodeToString(seal(m.aead, dataKey, []byte(m.Id)))
+}
+
+func (m *MasterKey) unwrap(wrappedKey string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(m.aead, sealed, []byte(m.Id))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce|ciphertext
+func seal(aead cipher.AEAD, data []byte, additional []byte) []byte {
+	nonce := utils.SecureRandomBytes(aead.NonceSize())
+
+	return aead.Seal(nonce, nonce, data, additional)
+}
+
+func open(aead cipher.AEAD, sealed []byte, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
+
+var Keys *Keyring
+
+// Init loads master keys from the config, encryption stays disabled without a key.
+func Init() (*Keyring, error) {
+	encoded := config.Config.EncryptionKey
+	if config.Config.EncryptionKeyFile != "" {
+		data, err := ioutil.ReadFile(config.Config.EncryptionKeyFile)
+		if err != nil {
+			return nil, errors.New("Can't read encryption key file: " + err.Error())
+		}
+		encoded = string(data)
+	}
+
+	var current *MasterKey
+	if encoded != "" {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		current = key
+	}
+
+	var old []*MasterKey
+	for _, encoded := range config.Config.EncryptionOldKeys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		old = append(old, key)
+	}
+
+	Keys = NewKeyring(current, old...)
+
+	return Keys, nil
+}
+
+// decodeKey parses a base64 encoded 32 bytes key
+func decodeKey(encoded string) (*MasterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("Can't decode encryption key: " + err.Error())
+	}
+
+	return NewMasterKey(key)
+}
@@ -0,0 +1,255 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"../config"
+)
+
+// Format of an uploaded document, detected by its content.
+type Format string
+
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	WEBP Format = "webp"
+	PDF  Format = "pdf"
+)
+
+const (
+	jpegQuality   = 90
+	ThumbnailSize = 320 // longest side in pixels
+)
+
+var extensions = map[string]Format{
+	"jpg":  JPEG,
+	"jpeg": JPEG,
+	"png":  PNG,
+	"webp": WEBP,
+	"pdf":  PDF,
+}
+
+var contentTypes = map[Format]string{
+	JPEG: "image/jpeg",
+	PNG:  "image/png",
+	WEBP: "image/webp",
+	PDF:  "application/pdf",
+}
+
+// FormatFromExtension maps a file extension, e.g. "jpg", to its format.
+func FormatFromExtension(ext string) (Format, bool) {
+	format, ok := extensions[strings.ToLower(ext)]
+	return format, ok
+}
+
+// Extension used for stored files.
+func (f Format) Extension() string {
+	return string(f)
+}
+
+func (f Format) ContentType() string {
+	return contentTypes[f]
+}
+
+// Upload is a validated file, ready to be stored.
+type Upload struct {
+	Data   []byte
+	Format Format
+	Width  int // zero for PDF
+	Height int
+
+	OriginalSize int64  // as uploaded, before re-encoding
+	OriginalHash string // hex encoded SHA-256 of the uploaded file
+}
+
+// ErrInvalid messages are safe to show to applicants.
+type ErrInvalid struct {
+	message string
+}
+
+func (e ErrInvalid) Error() string {
+	return e.message
+}
+
+func invalid(format string, args ...interface{}) error {
+	return ErrInvalid{message: fmt.Sprintf(format, args...)}
+}
+
+// Read reads and verifies an uploaded file, the file name extension must match the content.
+func Read(r io.Reader, filename string) (*Upload, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	declared, ok := extensions[ext]
+	if !ok {
+		return nil, invalid("Upload a JPEG, PNG, WebP or PDF file")
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.New("Can't read file: " + err.Error())
+	}
+
+	format, ok := Sniff(data)
+	if !ok {
+		return nil, invalid("The file is not a JPEG, PNG, WebP or PDF document")
+	}
+	if format != declared {
+		return nil, invalid("The file extension .%s does not match its %s content", ext, strings.ToUpper(string(format)))
+	}
+
+	sum := sha256.Sum256(data)
+	upload := &Upload{Data: data, Format: format, OriginalSize: int64(len(data)), OriginalHash: hex.EncodeToString(sum[:])}
+
+	if format == PDF {
+		// the end of file marker is required, may be followed by new lines
+		if !bytes.Contains(tail(data, 1024), []byte("%%EOF")) {
+			return nil, invalid("The PDF document is damaged")
+		}
+		return upload, nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, invalid("The image is damaged")
+	}
+
+	if err := checkDimensions(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+
+	// decode the whole image, the header alone does not prove it is valid
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return nil, invalid("The image is damaged")
+	}
+
+	upload.Width, upload.Height = cfg.Width, cfg.Height
+
+	return upload, nil
+}
+
+// Reencode decodes and encodes an image again, which drops EXIF, XMP and other metadata.
+// The image is rotated according to its EXIF orientation first. WebP images become JPEG,
+// PDF documents are left as is.
+func (u *Upload) Reencode() error {
+	if u.Format == PDF {
+		return nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(u.Data))
+	if err != nil {
+		return err
+	}
+
+	if u.Format == JPEG {
+		img = orient(img, jpegOrientation(u.Data))
+	}
+
+	var buf bytes.Buffer
+	if u.Format == PNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		u.Format = JPEG
+	}
+	if err != nil {
+		return err
+	}
+
+	u.Data = buf.Bytes()
+	u.Width, u.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	return nil
+}
+
+// Thumbnail returns a JPEG scaled down to ThumbnailSize, nil for PDF documents.
+func (u *Upload) Thumbnail() ([]byte, error) {
+	if u.Format == PDF {
+		return nil, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(u.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Format == JPEG {
+		img = orient(img, jpegOrientation(u.Data))
+	}
+
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width > ThumbnailSize || height > ThumbnailSize {
+		if width > height {
+			width, height = ThumbnailSize, height*ThumbnailSize/width
+		} else {
+			width, height = width*ThumbnailSize/height, ThumbnailSize
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Sniff detects a supported format by magic bytes.
+func Sniff(data []byte) (Format, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFF\xD8\xFF")):
+		return JPEG, true
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1A\n")):
+		return PNG, true
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return WEBP, true
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return PDF, true
+	}
+
+	return "", false
+}
+
+func checkDimensions(width int, height int) error {
+	min := config.Config.MinImageDimension
+	if min > 0 && (width < min || height < min) {
+		return invalid("The image is too small, at least %vx%v pixels required", min, min)
+	}
+
+	max := config.Config.MaxImageMegapixels
+	if max > 0 && int64(width)*int64(height) > int64(max)*1000000 {
+		return invalid("The image is too large, up to %v megapixels allowed", max)
+	}
+
+	return nil
+}
+
+func tail(data []byte, n int) []byte {
+	if len(data) < n {
+		return data
+	}
+
+	return data[len(data)-n:]
+}
@@ -0,0 +1,28 @@
+package job
+
+import (
+	"time"
+)
+
+// Every runs fn right away and then every interval until stop is called, errors are logged.
+func Every(interval time.Duration, name string, fn func() error) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			if err := fn(); err != nil {
+				println("Job " + name + " failed " + err.Error())
+			}
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,364 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"../db"
+	"../signer"
+	"../utils"
+
+	"github.com/go-ozzo/ozzo-validation"
+	"github.com/go-xorm/xorm"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	TOKEN_TYPE_ACCESS  = "access"
+	TOKEN_TYPE_REFRESH = "refresh"
+)
+
+var (
+	ErrAdminExists        = errors.New("An admin with this login already exists")
+	ErrInvalidCredentials = errors.New("Invalid login or password")
+	ErrInvalidToken       = errors.New("The token is invalid or has expired")
+)
+
+// Admin is an account of the admin section, actions are attributed to its login.
+type Admin struct {
+	Id           int64
+	Login        string `xorm:"varchar(255) not null unique"`
+	PasswordHash string `xorm:"varchar(255) not null" json:"-"`            // bcrypt
+	Role         string `xorm:"varchar(20) not null default 'superadmin'"` // accounts created before roles keep full access
+	Disabled     bool   `xorm:"not null default false"`
+	TotpSecret   string `xorm:"varchar(255)" json:"-"`      // base32, or base64 sealed with TotpDataKey, pending until TotpEnabled
+	TotpDataKey  string `xorm:"varchar(255)" json:"-"`      // wrapped data key, empty for an unencrypted secret
+	TotpKeyId    string `xorm:"varchar(16) index" json:"-"` // master key the data key is wrapped with
+	TotpEnabled  bool   `xorm:"not null default false"`
+	TotpLastStep int64  `json:"-"`                           // the last accepted time step, codes are single use
+	TokenVersion int64  `xorm:"not null default 0" json:"-"` // increased to reject every issued access token
+	LastLoginAt  pq.NullTime
+	CreatedAt    time.Time `xorm:"created"`
+	UpdatedAt    time.Time `xorm:"updated"`
+}
+
+func (a *Admin) TableName() string {
+	return "admins"
+}
+
+// AdminSession is a login of an admin, the refresh token carries its id and only a hash of it is stored.
+type AdminSession struct {
+	TokenHash string    `xorm:"varchar(64) not null pk" json:"-"`
+	AdminId   int64     `xorm:"not null index"`
+	Ip        string    `xorm:"varchar(45)"`
+	ExpiredAt time.Time `xorm:"not null"`
+	CreatedAt time.Time `xorm:"created"`
+}
+
+func (s *AdminSession) TableName() string {
+	return "admin_sessions"
+}
+
+// dummyHash is compared for unknown logins, so they take as long as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+func ValidatePassword(password string) error {
+	return validation.Validate(password, validation.Required, validation.Length(10, 72))
+}
+
+func ValidateRole(role string) error {
+	return validation.Validate(role, validation.Required, validation.In(Roles...))
+}
+
+func NewAdmin(login string, password string, role string) (*Admin, error) {
+	if err := validation.Validate(login, validation.Required, validation.Length(3, 255)); err != nil {
+		return nil, validation.Errors{"login": err}
+	}
+	if err := ValidateRole(role); err != nil {
+		return nil, validation.Errors{"role": err}
+	}
+	if err := ValidatePassword(password); err != nil {
+		return nil, validation.Errors{"password": err}
+	}
+
+	return insertAdmin(login, password, role)
+}
+
+func insertAdmin(login string, password string, role string) (*Admin, error) {
+	has, err := db.Engine.Where("login = ?", login).Exist(&Admin{})
+	if err != nil {
+		return nil, err
+	}
+	if has {
+		return nil, ErrAdminExists
+	}
+
+	a := &Admin{Login: login, Role: role}
+	if a.PasswordHash, err = hashPassword(password); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.Engine.InsertOne(a); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// BootstrapAdmin creates the first superadmin from the configured login, when there is no admin yet.
+// The legacy password is taken as it is, a weak one is only reported.
+func BootstrapAdmin(login string, password string) error {
+	if login == "" || password == "" {
+		return nil
+	}
+
+	count, err := db.Engine.Count(&Admin{})
+	if err != nil || count > 0 {
+		return err
+	}
+
+	if err := ValidatePassword(password); err != nil {
+		println("Warning: the password of the first admin " + login + " is weak, change it. " + err.Error())
+	}
+
+	_, err = insertAdmin(login, password, ROLE_SUPERADMIN)
+	return err
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func GetAdmin(id int64) (*Admin, bool, error) {
+	a := &Admin{}
+	has, err := db.Engine.ID(id).Get(a)
+
+	return a, has, err
+}
+
+func GetAdminByLogin(login string) (*Admin, bool, error) {
+	a := &Admin{}
+	has, err := db.Engine.Where("login = ?", login).Get(a)
+
+	return a, has, err
+}
+
+func GetAdmins() (admins []Admin, err error) {
+	err = db.Engine.Asc("login").Find(&admins)
+	return admins, err
+}
+
+// Authenticate checks the password of an enabled admin.
+func Authenticate(login string, password string) (*Admin, error) {
+	a := &Admin{}
+	has, err := db.Engine.Where("login = ?", login).Get(a)
+	if err != nil {
+		return nil, err
+	}
+
+	if !has || a.Disabled {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(a.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return a, nil
+}
+
+// SetPassword replaces the password and ends all sessions of the admin.
+func (a *Admin) SetPassword(password string) (err error) {
+	if err = ValidatePassword(password); err != nil {
+		return validation.Errors{"password": err}
+	}
+
+	if a.PasswordHash, err = hashPassword(password); err != nil {
+		return err
+	}
+
+	if _, err = db.Engine.ID(a.Id).Cols("password_hash").Update(a); err != nil {
+		return err
+	}
+
+	return a.RevokeSessions()
+}
+
+// ResetPassword sets a random password, it is shown once to the admin who reset it.
+func (a *Admin) ResetPassword() (string, error) {
+	password := utils.SecureRandomString(20)
+
+	return password, a.SetPassword(password)
+}
+
+// SetRole changes the permissions of the admin, they apply to the next request.
+func (a *Admin) SetRole(role string) error {
+	if err := ValidateRole(role); err != nil {
+		return validation.Errors{"role": err}
+	}
+
+	a.Role = role
+	_, err := db.Engine.ID(a.Id).Cols("role").Update(a)
+
+	return err
+}
+
+// SetDisabled blocks or allows the logins of the admin, disabling ends all sessions.
+func (a *Admin) SetDisabled(disabled bool) error {
+	a.Disabled = disabled
+	if _, err := db.Engine.ID(a.Id).Cols("disabled").Update(a); err != nil {
+		return err
+	}
+
+	if disabled {
+		return a.RevokeSessions()
+	}
+
+	return nil
+}
+
+// SessionTokens are issued by a login, the access token is checked without the database
+// and expires soon, the refresh token is stored as an AdminSession and renews it.
+type SessionTokens struct {
+	AccessToken      string    `json:"accessToken"`
+	AccessExpiredAt  time.Time `json:"accessExpiredAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiredAt time.Time `json:"refreshExpiredAt"`
+}
+
+// NewSession logs the admin in, only a hash of the session id is stored.
+func (a *Admin) NewSession(accessTtl time.Duration, refreshTtl time.Duration, ip string) (*SessionTokens, error) {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	tokens, err := a.newSession(tx, accessTtl, refreshTtl, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	a.LastLoginAt = pq.NullTime{Time: time.Now(), Valid: true}
+	_, err = db.Engine.ID(a.Id).Cols("last_login_at").Update(a)
+
+	return tokens, err
+}
+
+func (a *Admin) newSession(tx *xorm.Session, accessTtl time.Duration, refreshTtl time.Duration, ip string) (*SessionTokens, error) {
+	id := utils.SecureRandomString(48)
+	session := &AdminSession{
+		TokenHash: hashToken(id),
+		AdminId:   a.Id,
+		Ip:        ip,
+		ExpiredAt: time.Now().Add(refreshTtl),
+	}
+
+	refresh, err := signer.Token(signer.Claims{Subject: a.Id, Type: TOKEN_TYPE_REFRESH, Session: id}, refreshTtl)
+	if err != nil {
+		return nil, err
+	}
+	access, err := signer.Token(signer.Claims{Subject: a.Id, Type: TOKEN_TYPE_ACCESS, Version: a.TokenVersion}, accessTtl)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.InsertOne(session); err != nil {
+		return nil, err
+	}
+
+	return &SessionTokens{
+		AccessToken:      access,
+		AccessExpiredAt:  time.Now().Add(accessTtl),
+		RefreshToken:     refresh,
+		RefreshExpiredAt: session.ExpiredAt,
+	}, nil
+}
+
+// RefreshSession replaces a refresh token with new tokens, every refresh token works once.
+func RefreshSession(refreshToken string, accessTtl time.Duration, refreshTtl time.Duration, ip string) (*Admin, *SessionTokens, error) {
+	claims, err := signer.ParseToken(refreshToken, TOKEN_TYPE_REFRESH)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err = tx.Begin(); err != nil {
+		return nil, nil, err
+	}
+
+	// the conditional delete lets only one of concurrent refreshes through
+	affected, err := tx.Where("token_hash = ? AND admin_id = ? AND expired_at > ?", hashToken(claims.Session), claims.Subject, time.Now()).
+		Delete(&AdminSession{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if affected == 0 {
+		return nil, nil, ErrInvalidToken
+	}
+
+	a := &Admin{}
+	has, err := tx.ID(claims.Subject).Get(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !has || a.Disabled {
+		return nil, nil, ErrInvalidToken
+	}
+
+	tokens, err := a.newSession(tx, accessTtl, refreshTtl, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return a, tokens, tx.Commit()
+}
+
+// RevokeSessions ends every session, issued access tokens are rejected by their version.
+func (a *Admin) RevokeSessions() error {
+	if _, err := db.Engine.Exec("UPDATE admins SET token_version = token_version + 1 WHERE id = ?", a.Id); err != nil {
+		return err
+	}
+	a.TokenVersion++
+
+	_, err := db.Engine.Where("admin_id = ?", a.Id).Delete(&AdminSession{})
+	return err
+}
+
+// GetTokenAdmin returns the enabled admin of a valid access token.
+func GetTokenAdmin(accessToken string) (*Admin, bool, error) {
+	claims, err := signer.ParseToken(accessToken, TOKEN_TYPE_ACCESS)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	a, has, err := GetAdmin(claims.Subject)
+	if err != nil || !has || a.Disabled || a.TokenVersion != claims.Version {
+		return nil, false, err
+	}
+
+	return a, true, nil
+}
+
+// RevokeSession ends the session of a refresh token, its access tokens expire on their own.
+func RevokeSession(refreshToken string) error {
+	claims, err := signer.ParseToken(refreshToken, TOKEN_TYPE_REFRESH)
+	if err == signer.ErrExpired {
+		return nil
+	}
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	_, err = db.Engine.Where("token_hash = ?", hashToken(claims.Session)).Delete(&AdminSession{})
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
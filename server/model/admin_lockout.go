@@ -0,0 +1,247 @@
+package model
+
+import (
+	"time"
+
+	"../config"
+	"../db"
+
+	"github.com/lib/pq"
+)
+
+const (
+	LOCKOUT_LOGIN = "login"
+	LOCKOUT_IP    = "ip"
+)
+
+// reasons of login attempts
+const (
+	LOGIN_SUCCESS   = "success"
+	LOGIN_PASSWORD  = "password"  // unknown login, wrong password or disabled admin
+	LOGIN_CODE      = "code"      // wrong two-factor code
+	LOGIN_THROTTLED = "throttled" // rejected without checking the password
+)
+
+// AdminLoginAttempt is the audit log of admin logins.
+type AdminLoginAttempt struct {
+	Id        int64
+	Login     string    `xorm:"varchar(255) not null index"`
+	AdminId   int64     `xorm:"index"` // 0 for unknown logins
+	Ip        string    `xorm:"varchar(45) index"`
+	Success   bool      `xorm:"not null default false"`
+	Reason    string    `xorm:"varchar(20) not null"`
+	CreatedAt time.Time `xorm:"created index"`
+}
+
+func (a *AdminLoginAttempt) TableName() string {
+	return "admin_login_attempts"
+}
+
+// AdminLockout counts recent failed logins of a login or an IP, attempts are delayed and then locked out.
+type AdminLockout struct {
+	Id            int64
+	Kind          string    `xorm:"varchar(10) not null unique(kind_subject)"`
+	Subject       string    `xorm:"varchar(255) not null unique(kind_subject)"` // login or IP
+	Failures      int       `xorm:"not null default 0"`
+	LastFailureAt time.Time `xorm:"not null"`
+	NextAttemptAt time.Time `xorm:"not null"` // progressive delay
+	LockedUntil   pq.NullTime
+}
+
+func (l *AdminLockout) TableName() string {
+	return "admin_lockouts"
+}
+
+// LockoutDuration is how long a lockout lasts and how long failures are counted.
+func LockoutDuration() time.Duration {
+	if config.Config.AdminLockoutMinutes > 0 {
+		return time.Duration(config.Config.AdminLockoutMinutes) * time.Minute
+	}
+
+	return 15 * time.Minute
+}
+
+func maxFailures(kind string) int {
+	if kind == LOCKOUT_IP {
+		if config.Config.AdminMaxLoginFailuresPerIp > 0 {
+			return config.Config.AdminMaxLoginFailuresPerIp
+		}
+		return 50
+	}
+
+	if config.Config.AdminMaxLoginFailures > 0 {
+		return config.Config.AdminMaxLoginFailures
+	}
+	return 10
+}
+
+// loginDelay is free for the first half of the allowed failures of a login, then doubles from a second,
+// IPs are shared by many people and only locked out.
+func loginDelay(kind string, failures int) time.Duration {
+	free := maxFailures(kind) / 2
+	if kind == LOCKOUT_IP || failures < free {
+		return 0
+	}
+
+	delay := time.Second << uint(failures-free)
+	if delay > time.Minute || delay <= 0 {
+		return time.Minute
+	}
+
+	return delay
+}
+
+// LoginWait returns how long the login and the IP have to wait before the next attempt, 0 if it is allowed.
+func LoginWait(login string, ip string) (time.Duration, error) {
+	var lockouts []AdminLockout
+	err := db.Engine.Where("(kind = ? AND subject = ?) OR (kind = ? AND subject = ?)", LOCKOUT_LOGIN, login, LOCKOUT_IP, ip).
+		Find(&lockouts)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	wait := time.Duration(0)
+	for _, l := range lockouts {
+		if l.LockedUntil.Valid && l.LockedUntil.Time.Sub(now) > wait {
+			wait = l.LockedUntil.Time.Sub(now)
+		}
+		if l.NextAttemptAt.Sub(now) > wait {
+			wait = l.NextAttemptAt.Sub(now)
+		}
+	}
+
+	return wait, nil
+}
+
+// RecordLoginFailure logs a failed attempt and counts it for the login and the IP.
+func RecordLoginFailure(login string, adminId int64, ip string, reason string) error {
+	if err := logLoginAttempt(login, adminId, ip, false, reason); err != nil {
+		return err
+	}
+
+	if reason == LOGIN_THROTTLED {
+		return nil
+	}
+
+	if err := countFailure(LOCKOUT_LOGIN, login); err != nil {
+		return err
+	}
+	if ip != "" {
+		return countFailure(LOCKOUT_IP, ip)
+	}
+
+	return nil
+}
+
+// RecordLoginSuccess resets the failures of the login, logged tells whether the attempt goes to the audit log,
+// requests with basic auth are only logged when they fail.
+func RecordLoginSuccess(a *Admin, ip string, logged bool) error {
+	if logged {
+		if err := logLoginAttempt(a.Login, a.Id, ip, true, LOGIN_SUCCESS); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.Engine.Where("kind = ? AND subject = ? AND locked_until IS NULL", LOCKOUT_LOGIN, a.Login).
+		Delete(&AdminLockout{})
+	return err
+}
+
+func logLoginAttempt(login string, adminId int64, ip string, success bool, reason string) error {
+	_, err := db.Engine.InsertOne(&AdminLoginAttempt{Login: login, AdminId: adminId, Ip: ip, Success: success, Reason: reason})
+	return err
+}
+
+// countFailure increments the counter in place, so concurrent failures are all counted.
+// Concurrent first failures of a subject race on the insert, the loser counts again with the update.
+func countFailure(kind string, subject string) error {
+	for attempt := 0; ; attempt++ {
+		retry, err := incrementFailures(kind, subject)
+		if !retry || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// incrementFailures asks for a retry when the insert of a new counter failed
+func incrementFailures(kind string, subject string) (retry bool, err error) {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err := tx.Begin(); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	since := now.Add(-LockoutDuration())
+
+	// failures are counted within a lockout period, last_failure_at is assigned last
+	// because MySQL evaluates the assignments in order
+	result, err := tx.Exec("UPDATE admin_lockouts SET "+
+		"failures = CASE WHEN last_failure_at >= ? THEN failures + 1 ELSE 1 END, "+
+		"locked_until = CASE WHEN last_failure_at >= ? THEN locked_until ELSE NULL END, "+
+		"last_failure_at = ? "+
+		"WHERE kind = ? AND subject = ?", since, since, now, kind, subject)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if affected == 0 {
+		l := &AdminLockout{Kind: kind, Subject: subject, Failures: 1, LastFailureAt: now}
+		l.lock(now)
+		if _, err = tx.InsertOne(l); err != nil {
+			return true, err
+		}
+
+		return false, tx.Commit()
+	}
+
+	// the updated row stays locked until commit
+	l := &AdminLockout{}
+	if _, err = tx.Where("kind = ? AND subject = ?", kind, subject).Get(l); err != nil {
+		return false, err
+	}
+	l.lock(now)
+	if _, err = tx.ID(l.Id).Cols("next_attempt_at", "locked_until").Update(l); err != nil {
+		return false, err
+	}
+
+	return false, tx.Commit()
+}
+
+// lock delays the next attempt after the counted failures and locks the subject out after too many
+func (l *AdminLockout) lock(now time.Time) {
+	l.NextAttemptAt = now.Add(loginDelay(l.Kind, l.Failures))
+	if l.Failures >= maxFailures(l.Kind) {
+		l.LockedUntil = pq.NullTime{Time: now.Add(LockoutDuration()), Valid: true}
+	}
+}
+
+// GetLockouts returns logins and IPs with failures in the current lockout period.
+func GetLockouts() (lockouts []AdminLockout, err error) {
+	now := time.Now()
+	err = db.Engine.Where("last_failure_at > ? OR locked_until > ?", now.Add(-LockoutDuration()), now).
+		Desc("last_failure_at").Find(&lockouts)
+
+	return lockouts, err
+}
+
+// ClearLockout forgets the failures of a login or IP, the next attempt is allowed at once.
+func ClearLockout(id int64) (bool, error) {
+	affected, err := db.Engine.ID(id).Delete(&AdminLockout{})
+	return affected > 0, err
+}
+
+// PurgeLockouts deletes counters that have expired.
+func PurgeLockouts() error {
+	now := time.Now()
+	_, err := db.Engine.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-LockoutDuration()), now).
+		Delete(&AdminLockout{})
+
+	return err
+}
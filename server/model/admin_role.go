@@ -0,0 +1,77 @@
+package model
+
+// Permission is an action of the admin section, routes require one.
+type Permission string
+
+const (
+	PERMISSION_WHITELIST_VIEW      Permission = "whitelist.view"
+	PERMISSION_WHITELIST_DOCUMENTS Permission = "whitelist.documents"
+	PERMISSION_WHITELIST_NOTE      Permission = "whitelist.note"
+	PERMISSION_WHITELIST_QUESTION  Permission = "whitelist.question"
+	PERMISSION_WHITELIST_DECLINE   Permission = "whitelist.decline"
+	PERMISSION_WHITELIST_CLAIM     Permission = "whitelist.claim" // take applications from the review queue
+	PERMISSION_WHITELIST_ACCEPT    Permission = "whitelist.accept"
+	PERMISSION_WHITELIST_ASSIGN    Permission = "whitelist.assign" // hand applications to other reviewers
+	PERMISSION_WHITELIST_EXPORT    Permission = "whitelist.export"
+	PERMISSION_WHITELIST_ERASE     Permission = "whitelist.erase"
+	PERMISSION_EMAIL_MANAGE        Permission = "email.manage"
+	PERMISSION_ADMIN_MANAGE        Permission = "admin.manage"
+)
+
+const (
+	ROLE_VIEWER     = "viewer"
+	ROLE_REVIEWER   = "reviewer"
+	ROLE_APPROVER   = "approver"
+	ROLE_SUPERADMIN = "superadmin"
+)
+
+var viewerPermissions = []Permission{
+	PERMISSION_WHITELIST_VIEW,
+}
+
+var reviewerPermissions = append(viewerPermissions[:len(viewerPermissions):len(viewerPermissions)],
+	PERMISSION_WHITELIST_DOCUMENTS,
+	PERMISSION_WHITELIST_NOTE,
+	PERMISSION_WHITELIST_QUESTION,
+	PERMISSION_WHITELIST_DECLINE,
+	PERMISSION_WHITELIST_CLAIM,
+)
+
+var approverPermissions = append(reviewerPermissions[:len(reviewerPermissions):len(reviewerPermissions)],
+	PERMISSION_WHITELIST_ACCEPT,
+	PERMISSION_WHITELIST_ASSIGN,
+)
+
+var superadminPermissions = append(approverPermissions[:len(approverPermissions):len(approverPermissions)],
+	PERMISSION_WHITELIST_EXPORT,
+	PERMISSION_WHITELIST_ERASE,
+	PERMISSION_EMAIL_MANAGE,
+	PERMISSION_ADMIN_MANAGE,
+)
+
+// rolePermissions is the single source of what a role may do, every role extends the previous one
+var rolePermissions = map[string][]Permission{
+	ROLE_VIEWER:     viewerPermissions,
+	ROLE_REVIEWER:   reviewerPermissions,
+	ROLE_APPROVER:   approverPermissions,
+	ROLE_SUPERADMIN: superadminPermissions,
+}
+
+// Roles lists role names, the least privileged first.
+var Roles = []interface{}{ROLE_VIEWER, ROLE_REVIEWER, ROLE_APPROVER, ROLE_SUPERADMIN}
+
+// RolePermissions returns the permissions of a role, nil for unknown roles.
+func RolePermissions(role string) []Permission {
+	return rolePermissions[role]
+}
+
+// Can reports whether the role of the admin grants the permission.
+func (a *Admin) Can(permission Permission) bool {
+	for _, p := range rolePermissions[a.Role] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,252 @@
+package model
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"../config"
+	"../db"
+	"../encryption"
+	"../totp"
+	"../utils"
+
+	"github.com/lib/pq"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTotpEnabled    = errors.New("Two-factor authentication is already enabled")
+	ErrTotpNotStarted = errors.New("Two-factor authentication has not been set up")
+	ErrInvalidCode    = errors.New("The code is invalid or has already been used")
+)
+
+// AdminRecoveryCode is a single use replacement of a TOTP code, for a lost authenticator.
+type AdminRecoveryCode struct {
+	Id        int64
+	AdminId   int64  `xorm:"not null index"`
+	CodeHash  string `xorm:"varchar(64) not null"` // sha256 hex of the normalized code
+	UsedAt    pq.NullTime
+	CreatedAt time.Time `xorm:"created"`
+}
+
+func (c *AdminRecoveryCode) TableName() string {
+	return "admin_recovery_codes"
+}
+
+// TotpIssuer names the account in authenticator apps.
+func TotpIssuer() string {
+	if config.Config.TotpIssuer != "" {
+		return config.Config.TotpIssuer
+	}
+
+	return "MDL KYC"
+}
+
+// SetupTotp stores a new secret, it is used for logins once EnableTotp confirmed it.
+func (a *Admin) SetupTotp() (secret string, uri string, err error) {
+	if a.TotpEnabled {
+		return "", "", ErrTotpEnabled
+	}
+
+	secret = totp.GenerateSecret()
+	if err = a.sealTotpSecret(secret); err != nil {
+		return "", "", err
+	}
+	a.TotpLastStep = 0
+	if _, err = db.Engine.ID(a.Id).Cols("totp_secret", "totp_data_key", "totp_key_id", "totp_last_step").Update(a); err != nil {
+		return "", "", err
+	}
+
+	return secret, totp.URI(TotpIssuer(), a.Login, secret), nil
+}
+
+// sealTotpSecret encrypts the secret like uploads when master keys are configured.
+func (a *Admin) sealTotpSecret(secret string) error {
+	a.TotpSecret, a.TotpDataKey, a.TotpKeyId = secret, "", ""
+	if !encryption.Keys.Enabled() {
+		return nil
+	}
+
+	sealed, dataKey, keyId, err := encryption.Keys.Seal([]byte(secret))
+	if err != nil {
+		return errors.New("Can't encrypt TOTP secret: " + err.Error())
+	}
+	a.TotpSecret, a.TotpDataKey, a.TotpKeyId = base64.StdEncoding.EncodeToString(sealed), dataKey, keyId
+
+	return nil
+}
+
+// totpSecret returns the base32 secret, secrets stored before encryption was configured are plain.
+func (a *Admin) totpSecret() (string, error) {
+	if a.TotpKeyId == "" {
+		return a.TotpSecret, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(a.TotpSecret)
+	if err != nil {
+		return "", err
+	}
+	secret, err := encryption.Keys.Open(sealed, a.TotpDataKey, a.TotpKeyId)
+	if err != nil {
+		return "", errors.New("Can't decrypt TOTP secret: " + err.Error())
+	}
+
+	return string(secret), nil
+}
+
+// EnableTotp checks a code of the pending secret and returns new recovery codes, they are shown once.
+func (a *Admin) EnableTotp(code string) ([]string, error) {
+	if a.TotpEnabled {
+		return nil, ErrTotpEnabled
+	}
+	if a.TotpSecret == "" {
+		return nil, ErrTotpNotStarted
+	}
+
+	if err := a.useTotpCode(code); err != nil {
+		return nil, err
+	}
+
+	a.TotpEnabled = true
+	if _, err := db.Engine.ID(a.Id).Cols("totp_enabled").Update(a); err != nil {
+		return nil, err
+	}
+
+	return a.NewRecoveryCodes()
+}
+
+// DisableTotp removes the secret and the recovery codes.
+func (a *Admin) DisableTotp() error {
+	a.TotpEnabled = false
+	a.TotpSecret, a.TotpDataKey, a.TotpKeyId = "", "", ""
+	a.TotpLastStep = 0
+	if _, err := db.Engine.ID(a.Id).Cols("totp_enabled", "totp_secret", "totp_data_key", "totp_key_id", "totp_last_step").Update(a); err != nil {
+		return err
+	}
+
+	_, err := db.Engine.Where("admin_id = ?", a.Id).Delete(&AdminRecoveryCode{})
+	return err
+}
+
+// VerifySecondFactor accepts a current TOTP code or an unused recovery code.
+func (a *Admin) VerifySecondFactor(code string) error {
+	if !a.TotpEnabled {
+		return nil
+	}
+
+	if code = normalizeCode(code); len(code) == totp.Digits {
+		return a.useTotpCode(code)
+	}
+
+	return a.useRecoveryCode(code)
+}
+
+// useTotpCode accepts every step once, the update is conditional for concurrent logins.
+func (a *Admin) useTotpCode(code string) error {
+	secret, err := a.totpSecret()
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), a.TotpLastStep)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	affected, err := db.Engine.Where("totp_last_step < ?", step).ID(a.Id).
+		Cols("totp_last_step").Update(&Admin{TotpLastStep: step})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidCode
+	}
+	a.TotpLastStep = step
+
+	return nil
+}
+
+func (a *Admin) useRecoveryCode(code string) error {
+	affected, err := db.Engine.Where("admin_id = ? AND code_hash = ? AND used_at IS NULL", a.Id, hashToken(code)).
+		Cols("used_at").Update(&AdminRecoveryCode{UsedAt: pq.NullTime{Time: time.Now(), Valid: true}})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// NewRecoveryCodes replaces the recovery codes, only their hashes are stored.
+func (a *Admin) NewRecoveryCodes() ([]string, error) {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Where("admin_id = ?", a.Id).Delete(&AdminRecoveryCode{}); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(utils.SecureRandomBytes(5)))
+		codes[i] = code[:4] + "-" + code[4:]
+
+		if _, err := tx.InsertOne(&AdminRecoveryCode{AdminId: a.Id, CodeHash: hashToken(normalizeCode(code))}); err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// RecoveryCodesLeft counts the unused recovery codes.
+func (a *Admin) RecoveryCodesLeft() (int64, error) {
+	return db.Engine.Where("admin_id = ? AND used_at IS NULL", a.Id).Count(&AdminRecoveryCode{})
+}
+
+// normalizeCode drops the separators people type, recovery codes are case insensitive.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// RewrapTotpKeys wraps the data keys of TOTP secrets with the current master key,
+// secrets stored before encryption was configured are encrypted.
+func RewrapTotpKeys() (count int64, err error) {
+	if !encryption.Keys.Enabled() {
+		return 0, encryption.ErrUnknownKey
+	}
+
+	var admins []Admin
+	if err = db.Engine.Where("totp_secret <> '' AND (totp_key_id IS NULL OR totp_key_id <> ?)", encryption.Keys.Current.Id).Find(&admins); err != nil {
+		return 0, err
+	}
+
+	for _, a := range admins {
+		if a.TotpKeyId == "" {
+			err = a.sealTotpSecret(a.TotpSecret)
+		} else {
+			a.TotpDataKey, a.TotpKeyId, err = encryption.Keys.Rewrap(a.TotpDataKey, a.TotpKeyId)
+		}
+		if err != nil {
+			return count, fmt.Errorf("Can't rewrap TOTP key of adminId: %v %s", a.Id, err)
+		}
+
+		if _, err = db.Engine.ID(a.Id).NoAutoTime().Cols("totp_secret", "totp_data_key", "totp_key_id").Update(&a); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
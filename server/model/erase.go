@@ -0,0 +1,131 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"../db"
+
+	"github.com/lib/pq"
+)
+
+// ErrErased is returned for changes of an erased application.
+var ErrErased = errors.New("The application has been erased")
+
+// Erase deletes the documents and pseudonymizes personal data of the application.
+// The anonymized record, stage history and reason codes are kept for compliance.
+func (w *Whitelist) Erase(actor string) error {
+	if w.ErasedAt.Valid {
+		return ErrErased
+	}
+
+	photos, err := w.Photos()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+
+	email := w.Email
+
+	w.Name = "Erased"
+	w.Email = "erased-" + strconv.FormatInt(w.Id, 10) + "@invalid" // keeps the column unique
+	w.Phone = ""
+	w.Address = ""
+	w.Birthday = ""
+	w.ErasedAt = pq.NullTime{Time: time.Now(), Valid: true}
+	w.ErasedBy = actor
+
+	i, err := tx.ID(w.Id).Where("erased_at IS NULL").
+		Cols("name", "email", "phone", "address", "birthday", "erased_at", "erased_by").
+		Update(w)
+	if err != nil {
+		return err
+	}
+	if i == 0 {
+		return ErrErased
+	}
+
+	// photo rows stay as placeholders, applications reference them
+	for _, photo := range photos {
+		_, err := tx.ID(photo.Id).
+			Cols("path", "thumb_path", "data_key", "key_id", "original_hash").
+			Update(&Photo{Path: "erased/" + strconv.FormatInt(photo.Id, 10)})
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Where("whitelist_id = ?", w.Id).Delete(&WhitelistToken{}); err != nil {
+		return err
+	}
+
+	if _, err := tx.Where("whitelist_id = ?", w.Id).Cols("ip").Update(&WhitelistStageEvent{}); err != nil {
+		return err
+	}
+
+	if _, err := tx.Where("whitelist_id = ?", w.Id).Cols("note").Update(&WhitelistNote{}); err != nil {
+		return err
+	}
+
+	_, err = tx.Where("whitelist_id = ? AND status = ?", w.Id, EMAIL_PENDING).Cols("status").
+		Update(&WhitelistEmail{Status: EMAIL_SUPPRESSED})
+	if err != nil {
+		return err
+	}
+	// delivery errors often quote the recipient
+	_, err = tx.Where("whitelist_id = ?", w.Id).Cols("recipient", "text", "html", "error").
+		Update(&WhitelistEmail{Recipient: w.Email})
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Where("subject = ?", strings.ToLower(email)).Delete(&RateLimitEvent{}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, photo := range photos {
+		photo.DeleteFiles()
+	}
+
+	return nil
+}
+
+// EnforceRetention erases applications which stayed in a stage longer than its retention period,
+// e.g. {STAGE_DECLINED: 90 days}. With dryRun it only returns the ids that would be erased.
+func EnforceRetention(retention map[VerificationStage]time.Duration, dryRun bool) (ids []int64, err error) {
+	for stage, period := range retention {
+		var whitelists []Whitelist
+
+		err = db.Engine.
+			Where("verification_stage = ? AND erased_at IS NULL AND updated_at < ?", int(stage), time.Now().Add(-period)).
+			Asc("id").
+			Find(&whitelists)
+		if err != nil {
+			return ids, err
+		}
+
+		for i := range whitelists {
+			if !dryRun {
+				if err = whitelists[i].Erase(ACTOR_SYSTEM); err != nil {
+					return ids, fmt.Errorf("Can't erase whitelistId: %v %s", whitelists[i].Id, err)
+				}
+			}
+			ids = append(ids, whitelists[i].Id)
+		}
+	}
+
+	return ids, nil
+}
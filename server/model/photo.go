@@ -2,75 +2,187 @@ package model
 
 import (
 	"time"
-	"mime/multipart"
-	"path/filepath"
 	"strings"
 	"errors"
-	"os"
-	"io"
+	"bytes"
+	"fmt"
+	"io/ioutil"
 
+	"../config"
 	"../utils"
 	"../db"
+	"../storage"
+	"../encryption"
+	"../imaging"
 )
 
 // Photo is photo table structure.
 type Photo struct {
-	Id        int64
-	Path      string    `xorm:"varchar(255) not null unique"`
-	Extension string    `xorm:"varchar(5) not null"`
-	Src       string    `xorm:"-"`
-	CreatedAt time.Time `xorm:"created"`
+	Id           int64
+	WhitelistId  int64  `xorm:"index"` // owner, 0 until the application is stored
+	Path         string `xorm:"varchar(255) not null unique"`
+	Extension    string `xorm:"varchar(5) not null"`
+	ThumbPath    string `xorm:"varchar(255)" json:"-"`      // empty for documents without preview
+	DataKey      string `xorm:"varchar(255)" json:"-"`      // wrapped data key, empty for unencrypted files
+	KeyId        string `xorm:"varchar(16) index" json:"-"` // master key the data key is wrapped with
+	Width        int
+	Height       int
+	OriginalSize int64
+	OriginalHash string    `xorm:"varchar(64)"` // SHA-256 of the file as it was uploaded
+	Src          string    `xorm:"-"`           // thumbnail URL
+	Url          string    `xorm:"-"`           // original file URL
+	CreatedAt    time.Time `xorm:"created"`
 }
 
 func (p *Photo) TableName() string {
 	return "photos"
 }
 
+// Key returns the storage key, older records keep the local "./uploads/" prefix.
+func (p *Photo) Key() string {
+	return strings.TrimPrefix(p.Path, "./uploads/")
+}
+
 // CRUD
-func (p *Photo) StoreFile(file multipart.File, fileInfo *multipart.FileHeader) error {
-	defer file.Close()
+func (p *Photo) StoreFile(upload *imaging.Upload) error {
 	var (
-		path string
-		ext string
+		key      string
+		thumbKey string
+		ext      string
 		filename string
-		imgPath  string
+		data     []byte
+		thumb    []byte
+		err      error
 	)
 
-	ext = filepath.Ext(fileInfo.Filename)
-	ext = strings.ToLower(ext[1:]) // remove dot and cast to lower case
+	if config.Config.StripImageMetadata {
+		if err = upload.Reencode(); err != nil {
+			return errors.New("Can't re-encode image: " + err.Error())
+		}
+	}
+
+	ext = upload.Format.Extension()
 
 	// generate a new name if file exists
 	for {
 		filename = utils.RandomString(48)
-		imgPath = filename[0:3] + "/" + filename[3:6]
+		key = filename[0:3] + "/" + filename[3:6] + "/" + filename + "." + ext
+		thumbKey = filename[0:3] + "/" + filename[3:6] + "/" + filename + "_thumb.jpg"
 
-		// create path / bug with 0644
-		if err := os.MkdirAll("./uploads/"+imgPath, 0744); err != nil {
-			return errors.New("Can't create image path: " + err.Error())
-		}
-
-		path = "./uploads/" + imgPath + "/" + filename + "." + ext
-		if _, err := os.Stat(path); os.IsNotExist(err) {
+		_, err := storage.Store.Stat(key)
+		if err == storage.ErrNotExist {
 			break
 		}
+		if err != nil {
+			return errors.New("Can't check image path: " + err.Error())
+		}
 	}
 
-	// Create a file
-	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0744)
+	if thumb, err = upload.Thumbnail(); err != nil {
+		return errors.New("Can't create thumbnail: " + err.Error())
+	}
 
-	if err != nil {
+	data = upload.Data
+	if encryption.Keys.Enabled() {
+		if data, p.DataKey, p.KeyId, err = encryption.Keys.Seal(data); err != nil {
+			return errors.New("Can't encrypt file: " + err.Error())
+		}
+		// the thumbnail shares the data key of the original
+		if thumb != nil {
+			if thumb, err = encryption.Keys.SealWithKey(thumb, p.DataKey, p.KeyId); err != nil {
+				return errors.New("Can't encrypt thumbnail: " + err.Error())
+			}
+		}
+	}
+
+	if err := storage.Store.Put(key, bytes.NewReader(data)); err != nil {
 		return errors.New("Can't save file: " + err.Error())
 	}
-	defer out.Close()
 
-	io.Copy(out, file)
+	if thumb != nil {
+		if err := storage.Store.Put(thumbKey, bytes.NewReader(thumb)); err != nil {
+			return errors.New("Can't save thumbnail: " + err.Error())
+		}
+		p.ThumbPath = thumbKey
+	}
 
-	p.Path = path
+	p.Path = key
 	p.Extension = ext
+	p.Width = upload.Width
+	p.Height = upload.Height
+	p.OriginalSize = upload.OriginalSize
+	p.OriginalHash = upload.OriginalHash
 
 	if _, err := db.Engine.InsertOne(p); err != nil {
 		return errors.New("Can't insert photo into database: " + err.Error())
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// ContentType of the original file.
+func (p *Photo) ContentType() string {
+	if format, ok := imaging.FormatFromExtension(p.Extension); ok {
+		return format.ContentType()
+	}
+
+	return "application/octet-stream"
+}
+
+// ReadFile returns the decrypted file content.
+func (p *Photo) ReadFile() ([]byte, error) {
+	return p.readBlob(p.Key())
+}
+
+// ReadThumb returns the decrypted thumbnail, a JPEG image.
+func (p *Photo) ReadThumb() ([]byte, error) {
+	if p.ThumbPath == "" {
+		return nil, storage.ErrNotExist
+	}
+
+	return p.readBlob(p.ThumbPath)
+}
+
+func (p *Photo) readBlob(key string) ([]byte, error) {
+	r, err := storage.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil || p.KeyId == "" {
+		return data, err
+	}
+
+	return encryption.Keys.Open(data, p.DataKey, p.KeyId)
+}
+
+// RewrapPhotoKeys wraps data keys of all encrypted photos with the current master key.
+func RewrapPhotoKeys() (count int64, err error) {
+	if !encryption.Keys.Enabled() {
+		return 0, encryption.ErrUnknownKey
+	}
+
+	for {
+		var photos []Photo
+		err = db.Engine.
+			Where("key_id <> '' AND key_id <> ?", encryption.Keys.Current.Id).
+			Limit(100).
+			Find(&photos)
+		if err != nil || len(photos) == 0 {
+			return count, err
+		}
+
+		for _, photo := range photos {
+			if photo.DataKey, photo.KeyId, err = encryption.Keys.Rewrap(photo.DataKey, photo.KeyId); err != nil {
+				return count, fmt.Errorf("Can't rewrap key of photoId: %v %s", photo.Id, err)
+			}
+
+			if _, err = db.Engine.ID(photo.Id).Cols("data_key", "key_id").Update(&photo); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"../db"
+	"../storage"
+
+	"github.com/go-xorm/xorm"
+)
+
+// PhotoIds of all documents of the application.
+func (w *Whitelist) PhotoIds() []int64 {
+	ids := []int64{w.PassportId}
+	for _, id := range []sql.NullInt64{w.SelfieId, w.ResidentialPhotoId, w.StatementPhotoId} {
+		if id.Valid {
+			ids = append(ids, id.Int64)
+		}
+	}
+
+	return ids
+}
+
+// ownPhotos links the current documents to the application, replaced ones stay linked too.
+func (w *Whitelist) ownPhotos(tx *xorm.Session) error {
+	_, err := tx.In("id", w.PhotoIds()).Cols("whitelist_id").Update(&Photo{WhitelistId: w.Id})
+	return err
+}
+
+// Photos returns current and replaced documents of the application.
+func (w *Whitelist) Photos() ([]Photo, error) {
+	var photos []Photo
+	if err := db.Engine.In("id", w.PhotoIds()).Find(&photos); err != nil {
+		return nil, err
+	}
+
+	var owned []Photo
+	if err := db.Engine.Where("whitelist_id = ?", w.Id).NotIn("id", w.PhotoIds()).Find(&owned); err != nil {
+		return nil, err
+	}
+
+	return append(photos, owned...), nil
+}
+
+// Delete removes the application with its tokens, history, emails and documents.
+func (w *Whitelist) Delete() error {
+	_, err := w.delete("")
+	return err
+}
+
+// delete removes the application only if its row still matches the condition, deleted tells whether it did.
+func (w *Whitelist) delete(condition string, args ...interface{}) (deleted bool, err error) {
+	photos, err := w.Photos()
+	if err != nil {
+		return false, err
+	}
+
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err := tx.Begin(); err != nil {
+		return false, err
+	}
+
+	query := tx.ID(w.Id)
+	if condition != "" {
+		query = query.And(condition, args...)
+	}
+	affected, err := query.Delete(&Whitelist{})
+	if err != nil || affected == 0 {
+		return false, err
+	}
+
+	for _, bean := range []interface{}{&WhitelistToken{}, &WhitelistStageEvent{}, &WhitelistNote{}, &WhitelistEmail{}} {
+		if _, err := tx.Where("whitelist_id = ?", w.Id).Delete(bean); err != nil {
+			return false, err
+		}
+	}
+
+	for _, photo := range photos {
+		if _, err := tx.ID(photo.Id).Delete(&Photo{}); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	// files go last, a failed delete leaves an orphan file rather than a broken record
+	for _, photo := range photos {
+		photo.DeleteFiles()
+	}
+
+	return true, nil
+}
+
+// Delete removes a photo that no application refers to, with its files.
+func (p *Photo) Delete() error {
+	if _, err := db.Engine.ID(p.Id).Delete(&Photo{}); err != nil {
+		return err
+	}
+
+	p.DeleteFiles()
+	return nil
+}
+
+// DeleteFiles removes the file and the thumbnail from the storage.
+func (p *Photo) DeleteFiles() {
+	for _, key := range []string{p.Key(), p.ThumbPath} {
+		if key == "" {
+			continue
+		}
+		if err := storage.Store.Delete(key); err != nil && err != storage.ErrNotExist {
+			fmt.Printf("Can't delete file of photoId: %v \n\t %s\n", p.Id, err)
+		}
+	}
+}
+
+// PurgeUnconfirmed deletes unconfirmed applications whose confirmation links expired more than grace ago.
+// With dryRun it only returns the ids that would be deleted.
+func PurgeUnconfirmed(grace time.Duration, dryRun bool) (ids []int64, err error) {
+	var whitelists []Whitelist
+	cutoff := time.Now().Add(-grace)
+
+	// checked again by the delete, the applicant may request a new link in the meantime
+	condition := "verification_stage = ? AND created_at < ? AND " +
+		"NOT EXISTS (SELECT 1 FROM whitelist_tokens t WHERE t.whitelist_id = whitelists.id AND t.purpose = ? AND t.expired_at > ?)"
+	args := []interface{}{int(STAGE_EMAIL_NOT_CONFIRMED), cutoff, TOKEN_CONFIRM_EMAIL, cutoff}
+
+	err = db.Engine.Where(condition, args...).Asc("id").Find(&whitelists)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range whitelists {
+		if !dryRun {
+			deleted, err := whitelists[i].delete(condition, args...)
+			if err != nil {
+				return ids, fmt.Errorf("Can't delete whitelistId: %v %s", whitelists[i].Id, err)
+			}
+			if !deleted {
+				continue
+			}
+		}
+		ids = append(ids, whitelists[i].Id)
+	}
+
+	return ids, nil
+}
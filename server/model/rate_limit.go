@@ -0,0 +1,51 @@
+package model
+
+import (
+	"time"
+
+	"../db"
+)
+
+// rateLimitRetention is longer than every limit window, older events are purged.
+const rateLimitRetention = 24 * time.Hour
+
+// RateLimitEvent is a request counted against a limit, every instance of the application shares the table.
+type RateLimitEvent struct {
+	Id        int64
+	Scope     string    `xorm:"varchar(32) not null index(scope_subject)"`  // e.g. resend-email, resend-ip
+	Subject   string    `xorm:"varchar(255) not null index(scope_subject)"` // email or IP
+	CreatedAt time.Time `xorm:"created index"`
+}
+
+func (e *RateLimitEvent) TableName() string {
+	return "rate_limit_events"
+}
+
+// AllowEvent records an event of the subject and reports whether it is within limit events per window.
+// Concurrent events are ordered by id, so instances agree on which one exceeds the limit.
+func AllowEvent(scope string, subject string, limit int, window time.Duration) (bool, error) {
+	event := &RateLimitEvent{Scope: scope, Subject: subject}
+	if _, err := db.Engine.InsertOne(event); err != nil {
+		return false, err
+	}
+
+	count, err := db.Engine.
+		Where("scope = ? AND subject = ? AND id <= ? AND created_at > ?", scope, subject, event.Id, event.CreatedAt.Add(-window)).
+		Count(&RateLimitEvent{})
+	if err != nil {
+		return false, err
+	}
+	if count <= int64(limit) {
+		return true, nil
+	}
+
+	// limited requests do not count
+	_, err = db.Engine.ID(event.Id).Delete(&RateLimitEvent{})
+	return false, err
+}
+
+// PurgeRateLimitEvents deletes events that no window reaches anymore.
+func PurgeRateLimitEvents() error {
+	_, err := db.Engine.Where("created_at < ?", time.Now().Add(-rateLimitRetention)).Delete(&RateLimitEvent{})
+	return err
+}
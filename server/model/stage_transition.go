@@ -0,0 +1,79 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// StageHook runs after a stage change has been committed, e.g. to notify the applicant.
+type StageHook func(w *Whitelist, change StageChange)
+
+// Transition is an allowed verification stage change.
+type Transition struct {
+	From           VerificationStage
+	To             VerificationStage
+	ReasonRequired bool
+	Hooks          []StageHook
+}
+
+// transitions is the single source of allowed stage changes
+var transitions = []*Transition{
+	{From: STAGE_EMAIL_NOT_CONFIRMED, To: STAGE_EMAIL_CONFIRMED},
+
+	{From: STAGE_EMAIL_CONFIRMED, To: STAGE_ACCEPTED},
+	{From: STAGE_EMAIL_CONFIRMED, To: STAGE_DECLINED, ReasonRequired: true},
+	{From: STAGE_EMAIL_CONFIRMED, To: STAGE_QUESTION, ReasonRequired: true},
+
+	{From: STAGE_QUESTION, To: STAGE_EMAIL_CONFIRMED}, // the applicant replied, back to review
+	{From: STAGE_QUESTION, To: STAGE_ACCEPTED},
+	{From: STAGE_QUESTION, To: STAGE_DECLINED, ReasonRequired: true},
+
+	{From: STAGE_DECLINED, To: STAGE_ACCEPTED},
+	{From: STAGE_DECLINED, To: STAGE_QUESTION, ReasonRequired: true},
+}
+
+var ErrReasonRequired = errors.New("a reason is required for this decision")
+
+// TransitionError is returned for stage changes missing in the transition table.
+type TransitionError struct {
+	From VerificationStage
+	To   VerificationStage
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("An application can't be moved from %s to %s", e.From, e.To)
+}
+
+// FindTransition returns the transition between two stages or a TransitionError.
+func FindTransition(from VerificationStage, to VerificationStage) (*Transition, error) {
+	for _, t := range transitions {
+		if t.From == from && t.To == to {
+			return t, nil
+		}
+	}
+
+	return nil, &TransitionError{From: from, To: to}
+}
+
+// AddStageHook registers a hook for every transition into the stage.
+func AddStageHook(to VerificationStage, hook StageHook) {
+	for _, t := range transitions {
+		if t.To == to {
+			t.Hooks = append(t.Hooks, hook)
+		}
+	}
+}
+
+func (t *Transition) check(change StageChange) error {
+	if t.ReasonRequired && change.Reason == "" {
+		return ErrReasonRequired
+	}
+
+	return nil
+}
+
+func (t *Transition) runHooks(w *Whitelist, change StageChange) {
+	for _, hook := range t.Hooks {
+		hook(w, change)
+	}
+}
@@ -3,14 +3,15 @@ package model
 import (
 	"database/sql/driver"
 	"database/sql"
+	"fmt"
 	"time"
 
 	"./validation_rules"
-	"../utils"
 	"../db"
 
 	"github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/lib/pq"
 )
 
 // VerificationStage Type enumeration
@@ -27,6 +28,23 @@ const (
 func (u *VerificationStage) Scan(value interface{}) error { *u = VerificationStage(value.(uint8)); return nil }
 func (u VerificationStage) Value() (driver.Value, error)  { return uint8(u), nil }
 
+func (u VerificationStage) String() string {
+	switch u {
+	case STAGE_EMAIL_NOT_CONFIRMED:
+		return "unconfirmed"
+	case STAGE_EMAIL_CONFIRMED:
+		return "confirmed"
+	case STAGE_DECLINED:
+		return "declined"
+	case STAGE_QUESTION:
+		return "question"
+	case STAGE_ACCEPTED:
+		return "accepted"
+	}
+
+	return fmt.Sprintf("stage %d", uint8(u))
+}
+
 func NewVerificationStageFromString(s string) VerificationStage {
 	switch s {
 	case "unconfirmed":
@@ -50,7 +68,7 @@ func NewVerificationStageFromString(s string) VerificationStage {
 // Whitelist is whitelist table structure.
 type Whitelist struct {
 	Id                 int64
-	PassportId         int64             `xorm:"not null unique"`
+	PassportId         int64 `xorm:"not null unique"`
 	SelfieId           sql.NullInt64
 	ResidentialPhotoId sql.NullInt64
 	StatementPhotoId   sql.NullInt64
@@ -61,7 +79,12 @@ type Whitelist struct {
 	Birthday           string            `xorm:"varchar(255) not null"`
 	Country            string            `xorm:"varchar(255) not null"`
 	Citizenship        string            `xorm:"varchar(255) not null"`
+	Language           string            `xorm:"varchar(8) not null default 'en'"` // of emails to the applicant
 	VerificationStage  VerificationStage `xorm:"not null default 0"`
+	ErasedAt           pq.NullTime       // personal data was erased
+	ErasedBy           string            `xorm:"varchar(255)"`
+	AssigneeId         int64             `xorm:"not null default 0 index"` // admin reviewing the application
+	AssignedUntil      pq.NullTime       // the claim of the reviewer expires
 	CreatedAt          time.Time         `xorm:"created"`
 	UpdatedAt          time.Time         `xorm:"updated"`
 }
@@ -80,6 +103,19 @@ func (wp *WhitelistPassport) TableName() string {
 	return "whitelists"
 }
 
+// Whitelist with everything attached to it
+type WhitelistDetail struct {
+	Whitelist
+	Passport         *Photo
+	Selfie           *Photo
+	ResidentialPhoto *Photo
+	StatementPhoto   *Photo
+	Tokens           []WhitelistToken
+	StageEvents      []WhitelistStageEvent
+	Notes            []WhitelistNote
+	Emails           []WhitelistEmail
+}
+
 // validation
 func (w Whitelist) Validate() error {
 	return validation.ValidateStruct(&w,
@@ -94,7 +130,8 @@ func (w Whitelist) Validate() error {
 }
 
 // CRUD
-func (w *Whitelist) StoreData() (emailToken string, err error) {
+// StoreData inserts the application and queues its confirmation email in one transaction.
+func (w *Whitelist) StoreData(confirmation TokenEmail) (emailToken string, err error) {
 	tx := db.Engine.NewSession()
 	defer tx.Close()
 
@@ -106,32 +143,94 @@ func (w *Whitelist) StoreData() (emailToken string, err error) {
 		return "", err
 	}
 
-	var token string
-	// regenerate if not unique
-	for {
-		token = utils.SecureRandomString(35)
-		has, err := tx.Where("whitelist_id = ? AND token = ?", w.Id, token).Exist(&WhitelistToken{})
-		if err != nil {
-			return "", err
-		}
-		if !has {
-			break
-		}
-	}
-
-	wt := &WhitelistToken{
-		WhitelistId: w.Id,
-		Token:       token,
-		ExpiredAt:   time.Now().AddDate(0, 0, 7),
+	if err = w.ownPhotos(tx); err != nil {
+		return "", err
 	}
 
-	if _, err = tx.InsertOne(wt); err != nil {
+	token, err := w.sendToken(tx, TOKEN_CONFIRM_EMAIL, 7*24*time.Hour, confirmation)
+	if err != nil {
 		return "", err
 	}
 
 	return token, tx.Commit()
 }
 
+// ResendConfirmation invalidates unused confirmation tokens and queues an email with a fresh one.
+func (w *Whitelist) ResendConfirmation(confirmation TokenEmail) error {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+
+	if err := expireTokens(tx, w.Id, TOKEN_CONFIRM_EMAIL); err != nil {
+		return err
+	}
+
+	if _, err := w.sendToken(tx, TOKEN_CONFIRM_EMAIL, 7*24*time.Hour, confirmation); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func GetWhitelist(id int64) (*Whitelist, bool, error) {
+	w := &Whitelist{}
+	has, err := db.Engine.ID(id).Get(w)
+
+	return w, has, err
+}
+
+func GetWhitelistByEmail(email string) (*Whitelist, bool, error) {
+	w := &Whitelist{}
+	has, err := db.Engine.Where("email = ?", email).Get(w)
+
+	return w, has, err
+}
+
 func (w *Whitelist) EmailExist() (has bool, err error) {
 	return db.Engine.Select("id").Where("email = ?", w.Email).Exist(&Whitelist{})
 }
+
+func GetWhitelistDetail(id int64) (wd *WhitelistDetail, has bool, err error) {
+	wd = &WhitelistDetail{}
+	if has, err = db.Engine.ID(id).Get(&wd.Whitelist); err != nil || !has {
+		return nil, has, err
+	}
+
+	var photos []Photo
+	if err = db.Engine.In("id", wd.PhotoIds()).Find(&photos); err != nil {
+		return nil, true, err
+	}
+
+	for i := range photos {
+		photo := &photos[i]
+		switch photo.Id {
+		case wd.PassportId:
+			wd.Passport = photo
+		case wd.SelfieId.Int64:
+			wd.Selfie = photo
+		case wd.ResidentialPhotoId.Int64:
+			wd.ResidentialPhoto = photo
+		case wd.StatementPhotoId.Int64:
+			wd.StatementPhoto = photo
+		}
+	}
+
+	if err = db.Engine.Where("whitelist_id = ?", id).Asc("created_at").Find(&wd.Tokens); err != nil {
+		return nil, true, err
+	}
+
+	if wd.StageEvents, err = GetStageEvents(id); err != nil {
+		return nil, true, err
+	}
+
+	if wd.Notes, err = GetNotes(id); err != nil {
+		return nil, true, err
+	}
+
+	wd.Emails, err = GetEmails(id)
+
+	return wd, true, err
+}
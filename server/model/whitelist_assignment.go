@@ -0,0 +1,92 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"../db"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNotAssigned     = errors.New("The application is not assigned to you")
+	ErrAssignedToOther = errors.New("The application is assigned to another reviewer")
+)
+
+// ClaimNextWhitelist assigns the oldest confirmed application nobody holds to the admin for the lease,
+// an application the admin already holds comes first and its lease is renewed.
+func ClaimNextWhitelist(adminId int64, lease time.Duration) (*Whitelist, bool, error) {
+	now := time.Now()
+	until := pq.NullTime{Time: now.Add(lease), Valid: true}
+
+	w := &Whitelist{}
+	has, err := db.Engine.
+		Where("verification_stage = ? AND erased_at IS NULL AND assignee_id = ? AND assigned_until > ?", int(STAGE_EMAIL_CONFIRMED), adminId, now).
+		Asc("id").
+		Get(w)
+	if err != nil {
+		return nil, false, err
+	}
+	if has {
+		w.AssignedUntil = until
+		// claims keep updated_at, the retention period runs from it
+		_, err = db.Engine.ID(w.Id).NoAutoTime().Cols("assigned_until").Update(w)
+		return w, true, err
+	}
+
+	// another reviewer may claim the same application first, the conditional update tells
+	for {
+		w = &Whitelist{}
+		has, err = db.Engine.
+			Where("verification_stage = ? AND erased_at IS NULL AND (assignee_id = 0 OR assigned_until IS NULL OR assigned_until <= ?)", int(STAGE_EMAIL_CONFIRMED), now).
+			Asc("id").
+			Get(w)
+		if err != nil || !has {
+			return nil, false, err
+		}
+
+		affected, err := db.Engine.NoAutoTime().
+			Where("id = ? AND verification_stage = ? AND (assignee_id = 0 OR assigned_until IS NULL OR assigned_until <= ?)", w.Id, int(STAGE_EMAIL_CONFIRMED), now).
+			Cols("assignee_id", "assigned_until").
+			Update(&Whitelist{AssigneeId: adminId, AssignedUntil: until})
+		if err != nil {
+			return nil, false, err
+		}
+		if affected == 1 {
+			w.AssigneeId = adminId
+			w.AssignedUntil = until
+			return w, true, nil
+		}
+	}
+}
+
+// Release gives the application of the admin back to the queue.
+func (w *Whitelist) Release(adminId int64) error {
+	affected, err := db.Engine.ID(w.Id).NoAutoTime().Where("assignee_id = ?", adminId).
+		Cols("assignee_id", "assigned_until").
+		Update(&Whitelist{})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotAssigned
+	}
+
+	w.AssigneeId = 0
+	w.AssignedUntil = pq.NullTime{}
+
+	return nil
+}
+
+// Assign hands the application to another admin with a new lease, 0 returns it to the queue.
+func (w *Whitelist) Assign(adminId int64, lease time.Duration) error {
+	w.AssigneeId = adminId
+	w.AssignedUntil = pq.NullTime{}
+	if adminId != 0 {
+		w.AssignedUntil = pq.NullTime{Time: time.Now().Add(lease), Valid: true}
+	}
+
+	_, err := db.Engine.ID(w.Id).NoAutoTime().Cols("assignee_id", "assigned_until").Update(w)
+	return err
+}
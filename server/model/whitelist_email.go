@@ -0,0 +1,154 @@
+package model
+
+import (
+	"time"
+
+	"../db"
+	"github.com/go-xorm/xorm"
+)
+
+const (
+	EMAIL_PENDING    = "pending"    // waiting for the first or a next attempt
+	EMAIL_SENT       = "sent"
+	EMAIL_FAILED     = "failed"     // attempts exhausted, needs a manual re-send
+	EMAIL_SUPPRESSED = "suppressed" // a reviewer decided without notifying the applicant
+)
+
+// WhitelistEmail is the outbox of emails to applicants and the log of sent ones.
+type WhitelistEmail struct {
+	Id            int64
+	WhitelistId   int64     `xorm:"not null index"`
+	Kind          string    `xorm:"varchar(32) not null"` // confirm_email, accepted, declined, question
+	Sender        string    `xorm:"varchar(255) not null"`
+	Recipient     string    `xorm:"varchar(255) not null"`
+	ReplyTo       string    `xorm:"varchar(255)"`
+	Subject       string    `xorm:"varchar(255) not null"`
+	Text          string    `xorm:"text"`
+	Html          string    `xorm:"text"`
+	Status        string    `xorm:"varchar(16) not null index"`
+	Attempts      int       `xorm:"not null default 0"`
+	Error         string    `xorm:"varchar(1000)"` // error of the last attempt
+	MessageId     string    `xorm:"varchar(255)"`
+	NextAttemptAt time.Time `xorm:"index"`
+	SentAt        time.Time
+	CreatedAt     time.Time `xorm:"created"`
+}
+
+func (e *WhitelistEmail) TableName() string {
+	return "whitelist_emails"
+}
+
+// Enqueue stores the email for the delivery worker.
+func (e *WhitelistEmail) Enqueue() error {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	return e.enqueue(tx)
+}
+
+func (e *WhitelistEmail) enqueue(tx *xorm.Session) error {
+	if e.Status == "" {
+		e.Status = EMAIL_PENDING
+	}
+	e.NextAttemptAt = time.Now()
+
+	_, err := tx.InsertOne(e)
+	return err
+}
+
+// ClaimEmails leases due emails to a worker, so concurrent workers do not send them twice.
+func ClaimEmails(limit int, lease time.Duration) (claimed []WhitelistEmail, err error) {
+	var emails []WhitelistEmail
+	now := time.Now()
+
+	err = db.Engine.
+		Where("status = ? AND next_attempt_at <= ?", EMAIL_PENDING, now).
+		Asc("next_attempt_at").
+		Limit(limit).
+		Find(&emails)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range emails {
+		ok, err := e.claim(now, lease)
+		if err != nil {
+			return claimed, err
+		}
+		if ok {
+			claimed = append(claimed, e)
+		}
+	}
+
+	return claimed, nil
+}
+
+// Claim leases the email if it is due, so a worker does not send it at the same time.
+func (e *WhitelistEmail) Claim(lease time.Duration) (bool, error) {
+	return e.claim(time.Now(), lease)
+}
+
+func (e *WhitelistEmail) claim(now time.Time, lease time.Duration) (bool, error) {
+	affected, err := db.Engine.
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", e.Id, EMAIL_PENDING, now).
+		Cols("next_attempt_at").
+		Update(&WhitelistEmail{NextAttemptAt: now.Add(lease)})
+
+	return affected == 1, err
+}
+
+func (e *WhitelistEmail) Delivered(messageId string) error {
+	e.Status = EMAIL_SENT
+	e.Attempts++
+	e.Error = ""
+	e.MessageId = messageId
+	e.SentAt = time.Now()
+
+	_, err := db.Engine.ID(e.Id).Cols("status", "attempts", "error", "message_id", "sent_at").Update(e)
+	return err
+}
+
+// Undelivered schedules the next attempt, or marks the email failed when next is zero.
+func (e *WhitelistEmail) Undelivered(sendErr error, next time.Time) error {
+	e.Attempts++
+	e.Error = sendErr.Error()
+	if len(e.Error) > 1000 {
+		e.Error = e.Error[:1000]
+	}
+	if next.IsZero() {
+		e.Status = EMAIL_FAILED
+	} else {
+		e.NextAttemptAt = next
+	}
+
+	_, err := db.Engine.ID(e.Id).Cols("status", "attempts", "error", "next_attempt_at").Update(e)
+	return err
+}
+
+// Resend queues a failed email again with a fresh set of attempts.
+func (e *WhitelistEmail) Resend() (bool, error) {
+	queued := WhitelistEmail{Status: EMAIL_PENDING, Attempts: 0, NextAttemptAt: time.Now()}
+	affected, err := db.Engine.
+		Where("id = ? AND status = ?", e.Id, EMAIL_FAILED).
+		Cols("status", "attempts", "next_attempt_at").
+		Update(&queued)
+	if err != nil || affected != 1 {
+		return false, err
+	}
+
+	e.Status, e.Attempts, e.NextAttemptAt = queued.Status, queued.Attempts, queued.NextAttemptAt
+	return true, nil
+}
+
+func GetEmail(id int64) (*WhitelistEmail, bool, error) {
+	e := &WhitelistEmail{}
+	has, err := db.Engine.ID(id).Get(e)
+
+	return e, has, err
+}
+
+// GetEmails lists the emails of a whitelist without the bodies, they may contain links with valid tokens.
+func GetEmails(whitelistId int64) (emails []WhitelistEmail, err error) {
+	err = db.Engine.Where("whitelist_id = ?", whitelistId).Omit("text", "html").Asc("id").Find(&emails)
+	return emails, err
+}
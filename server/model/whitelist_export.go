@@ -0,0 +1,154 @@
+package model
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"../storage"
+	"github.com/lib/pq"
+)
+
+// WhitelistExport is the personal data of an applicant, written as data.json of the archive.
+type WhitelistExport struct {
+	Whitelist   Whitelist
+	StageEvents []WhitelistStageEvent
+	Notes       []WhitelistNote
+	Emails      []ExportedEmail
+	Documents   []ExportedDocument
+	ExportedAt  time.Time
+}
+
+// ExportedEmail leaves the body out, it may contain links with valid tokens.
+type ExportedEmail struct {
+	Kind      string
+	Recipient string
+	Subject   string
+	Status    string
+	SentAt    time.Time
+	CreatedAt time.Time
+}
+
+type ExportedDocument struct {
+	File string // path in the archive, empty if the file is gone
+	Photo
+}
+
+// WriteArchive streams a ZIP with data.json and the uploaded documents, one file in memory at a time.
+// The copy for the applicant has only the shared notes and leaves out who reviewed the application.
+func (w *Whitelist) WriteArchive(out io.Writer, forApplicant bool) error {
+	var (
+		data WhitelistExport
+		err  error
+	)
+
+	data.Whitelist = *w
+	data.ExportedAt = time.Now()
+
+	if data.StageEvents, err = GetStageEvents(w.Id); err != nil {
+		return err
+	}
+	if data.Notes, err = GetNotes(w.Id); err != nil {
+		return err
+	}
+	if forApplicant {
+		data.forApplicant()
+	}
+
+	emails, err := GetEmails(w.Id)
+	if err != nil {
+		return err
+	}
+	for _, e := range emails {
+		data.Emails = append(data.Emails, ExportedEmail{
+			Kind:      e.Kind,
+			Recipient: e.Recipient,
+			Subject:   e.Subject,
+			Status:    e.Status,
+			SentAt:    e.SentAt,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	photos, err := w.Photos()
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(out)
+
+	for _, photo := range photos {
+		document := ExportedDocument{Photo: photo}
+
+		content, err := photo.ReadFile()
+		if err == storage.ErrNotExist {
+			data.Documents = append(data.Documents, document)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Can't read photoId: %v %s", photo.Id, err)
+		}
+
+		document.File = "documents/" + w.documentName(photo.Id) + "." + photo.Extension
+		f, err := archive.Create(document.File)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(content); err != nil {
+			return err
+		}
+
+		data.Documents = append(data.Documents, document)
+	}
+
+	f, err := archive.Create("data.json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(data); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// forApplicant removes internal notes and the reviewer data of the export.
+func (data *WhitelistExport) forApplicant() {
+	data.Whitelist.AssigneeId = 0
+	data.Whitelist.AssignedUntil = pq.NullTime{}
+	data.Whitelist.ErasedBy = ""
+
+	for i := range data.StageEvents {
+		data.StageEvents[i].Actor = ""
+		data.StageEvents[i].Ip = ""
+	}
+
+	var shared []WhitelistNote
+	for _, note := range data.Notes {
+		if note.ShareWithApplicant {
+			note.Author = ""
+			shared = append(shared, note)
+		}
+	}
+	data.Notes = shared
+}
+
+func (w *Whitelist) documentName(photoId int64) string {
+	switch photoId {
+	case w.PassportId:
+		return "passport"
+	case w.SelfieId.Int64:
+		return "selfie"
+	case w.ResidentialPhotoId.Int64:
+		return "residential-photo"
+	case w.StatementPhotoId.Int64:
+		return "statement-photo"
+	}
+
+	return fmt.Sprintf("replaced-%v", photoId)
+}
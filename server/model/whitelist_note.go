@@ -0,0 +1,60 @@
+package model
+
+import (
+	"time"
+
+	"../db"
+)
+
+// WhitelistNote is a reviewer note, decisions keep their reason code in it.
+type WhitelistNote struct {
+	Id                 int64
+	WhitelistId        int64     `xorm:"not null index"`
+	StageEventId       int64     `xorm:"index"` // decision the note explains, 0 for plain notes
+	Author             string    `xorm:"varchar(255) not null"`
+	ReasonCode         string    `xorm:"varchar(64)"`
+	Note               string    `xorm:"varchar(2000)"`
+	ShareWithApplicant bool      `xorm:"not null default false"` // included in the notification email
+	CreatedAt          time.Time `xorm:"created"`
+}
+
+func (n *WhitelistNote) TableName() string {
+	return "whitelist_notes"
+}
+
+func (n *WhitelistNote) Store() error {
+	_, err := db.Engine.InsertOne(n)
+	return err
+}
+
+func GetNotes(whitelistId int64) (notes []WhitelistNote, err error) {
+	err = db.Engine.Where("whitelist_id = ?", whitelistId).Asc("id").Find(&notes)
+	return notes, err
+}
+
+// GetLastQuestion returns the note of the latest question decision.
+func GetLastQuestion(whitelistId int64) (note *WhitelistNote, has bool, err error) {
+	note = &WhitelistNote{}
+	has, err = db.Engine.
+		Table("whitelist_notes").Alias("n").
+		Select("n.*").
+		Join("INNER", []string{"whitelist_stage_events", "e"}, "e.id = n.stage_event_id").
+		Where("n.whitelist_id = ? AND e.to_stage = ?", whitelistId, int(STAGE_QUESTION)).
+		Desc("n.id").
+		Get(note)
+
+	return note, has, err
+}
+
+// ApplicantMessage explains a decision to the applicant: the reason text and the note if it is shared.
+func ApplicantMessage(reasons map[string]string, code string, note string, share bool) string {
+	message := reasons[code]
+	if share && note != "" {
+		if message != "" {
+			message += "\n\n"
+		}
+		message += note
+	}
+
+	return message
+}
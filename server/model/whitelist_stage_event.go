@@ -0,0 +1,178 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"../db"
+
+	"github.com/go-xorm/xorm"
+	"github.com/lib/pq"
+)
+
+const (
+	ACTOR_APPLICANT = "applicant"
+	ACTOR_SYSTEM    = "system"
+)
+
+// WhitelistStageEvent is an audit record of a verification stage change.
+type WhitelistStageEvent struct {
+	Id          int64
+	WhitelistId int64             `xorm:"not null index"`
+	FromStage   VerificationStage `xorm:"not null"`
+	ToStage     VerificationStage `xorm:"not null"`
+	Actor       string            `xorm:"varchar(255) not null"` // admin login, applicant or system
+	Reason      string            `xorm:"varchar(1000)"`
+	Ip          string            `xorm:"varchar(45)"`
+	CreatedAt   time.Time         `xorm:"created"`
+}
+
+func (e *WhitelistStageEvent) TableName() string {
+	return "whitelist_stage_events"
+}
+
+// StageChange describes who moves an application to which stage.
+type StageChange struct {
+	To      VerificationStage
+	Actor   string
+	AdminId int64  // deciding admin, the change fails while another reviewer holds the application
+	Reason  string // reason code of a decision
+	Ip      string
+
+	Note               string // reviewer note stored with the decision
+	ShareWithApplicant bool
+	SuppressEmail      bool // do not notify the applicant
+}
+
+var ErrStageChanged = errors.New("verification stage has been changed concurrently")
+
+// ChangeStage updates the verification stage and records the event in one transaction,
+// the change must be allowed by the transition table. Hooks run after commit.
+func (w *Whitelist) ChangeStage(change StageChange) error {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+
+	transition, err := w.changeStage(tx, change)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	transition.runHooks(w, change)
+
+	return nil
+}
+
+// changeStage fails with ErrStageChanged unless the stored stage is still w.VerificationStage
+func (w *Whitelist) changeStage(tx *xorm.Session, change StageChange) (*Transition, error) {
+	if w.ErasedAt.Valid {
+		return nil, ErrErased
+	}
+
+	transition, err := FindTransition(w.VerificationStage, change.To)
+	if err != nil {
+		return nil, err
+	}
+	if err = transition.check(change); err != nil {
+		return nil, err
+	}
+
+	event := &WhitelistStageEvent{
+		WhitelistId: w.Id,
+		FromStage:   w.VerificationStage,
+		ToStage:     change.To,
+		Actor:       change.Actor,
+		Reason:      change.Reason,
+		Ip:          change.Ip,
+	}
+
+	// a decided application leaves the review queue, it is claimed anew once it returns to review
+	query := tx.ID(w.Id).Where("verification_stage = ?", int(w.VerificationStage))
+	if change.AdminId != 0 {
+		query = query.And("(assignee_id IN (0, ?) OR assigned_until IS NULL OR assigned_until <= ?)", change.AdminId, time.Now())
+	}
+	i, err := query.
+		Cols("verification_stage", "assignee_id", "assigned_until").
+		Update(&Whitelist{VerificationStage: change.To})
+	if err != nil {
+		return nil, err
+	}
+	if i == 0 {
+		return nil, w.changeStageConflict(tx)
+	}
+
+	// links to answer a question end with it
+	if w.VerificationStage == STAGE_QUESTION && change.To != STAGE_QUESTION {
+		if err = expireTokens(tx, w.Id, TOKEN_QUESTION_REPLY); err != nil {
+			return nil, fmt.Errorf("Can't expire reply tokens: %s", err)
+		}
+	}
+
+	if _, err = tx.InsertOne(event); err != nil {
+		return nil, fmt.Errorf("Can't insert stage event: %s", err)
+	}
+
+	if change.Reason != "" || change.Note != "" {
+		note := &WhitelistNote{
+			WhitelistId:        w.Id,
+			StageEventId:       event.Id,
+			Author:             change.Actor,
+			ReasonCode:         change.Reason,
+			Note:               change.Note,
+			ShareWithApplicant: change.ShareWithApplicant,
+		}
+		if _, err = tx.InsertOne(note); err != nil {
+			return nil, fmt.Errorf("Can't insert note: %s", err)
+		}
+	}
+
+	w.VerificationStage = change.To
+	w.AssigneeId = 0
+	w.AssignedUntil = pq.NullTime{}
+
+	return transition, nil
+}
+
+// changeStageConflict tells why the conditional update of changeStage matched no row
+func (w *Whitelist) changeStageConflict(tx *xorm.Session) error {
+	has, err := tx.ID(w.Id).Where("verification_stage = ?", int(w.VerificationStage)).Exist(&Whitelist{})
+	if err != nil {
+		return err
+	}
+	if has {
+		return ErrAssignedToOther
+	}
+
+	return ErrStageChanged
+}
+
+func GetStageEvents(whitelistId int64) (events []WhitelistStageEvent, err error) {
+	err = db.Engine.Where("whitelist_id = ?", whitelistId).Asc("id").Find(&events)
+	return events, err
+}
+
+// GetStageTimes returns when each application last entered each stage.
+func GetStageTimes(whitelistIds []int64) (map[int64]map[VerificationStage]time.Time, error) {
+	var events []WhitelistStageEvent
+	if err := db.Engine.In("whitelist_id", whitelistIds).Asc("id").Find(&events); err != nil {
+		return nil, err
+	}
+
+	times := map[int64]map[VerificationStage]time.Time{}
+	for _, e := range events {
+		if times[e.WhitelistId] == nil {
+			times[e.WhitelistId] = map[VerificationStage]time.Time{}
+		}
+		times[e.WhitelistId][e.ToStage] = e.CreatedAt
+	}
+
+	return times, nil
+}
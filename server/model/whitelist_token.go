@@ -4,30 +4,153 @@ import (
 	"time"
 	"fmt"
 	"errors"
+	"database/sql"
 
 	"../db"
+	"../utils"
 
+	"github.com/go-xorm/xorm"
 	"github.com/lib/pq"
 )
 
+const (
+	TOKEN_CONFIRM_EMAIL  = "confirm_email"
+	TOKEN_QUESTION_REPLY = "question_reply"
+	TOKEN_DATA_EXPORT    = "data_export"
+)
+
+// TokenEmail builds an email with a link containing the token.
+type TokenEmail func(w *Whitelist, token string) (*WhitelistEmail, error)
+
 type WhitelistToken struct {
 	WhitelistId int64
-	Token       string    `xorm:"varchar(128) not null pk"`
+	Token       string    `xorm:"varchar(128) not null pk" json:"-"`
+	Purpose     string    `xorm:"varchar(32) not null default 'confirm_email'"`
 	CreatedAt   time.Time `xorm:"created"`
 	ExpiredAt   time.Time
 	UsedAt      pq.NullTime
 }
 
+// QuestionReply is the applicant answer to a question of a reviewer.
+type QuestionReply struct {
+	Text               string
+	SelfieId           sql.NullInt64
+	ResidentialPhotoId sql.NullInt64
+	StatementPhotoId   sql.NullInt64
+}
+
 func (wt *WhitelistToken) TableName() string {
 	return "whitelist_tokens"
 }
 
+// newToken issues a token of the purpose within the transaction
+func newToken(tx *xorm.Session, whitelistId int64, purpose string, ttl time.Duration) (token string, err error) {
+	// regenerate if not unique
+	for {
+		token = utils.SecureRandomString(35)
+		has, err := tx.Where("token = ?", token).Exist(&WhitelistToken{})
+		if err != nil {
+			return "", err
+		}
+		if !has {
+			break
+		}
+	}
+
+	wt := &WhitelistToken{
+		WhitelistId: whitelistId,
+		Token:       token,
+		Purpose:     purpose,
+		ExpiredAt:   time.Now().Add(ttl),
+	}
+
+	if _, err = tx.InsertOne(wt); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// expireTokens invalidates unused tokens of the purpose within the transaction
+func expireTokens(tx *xorm.Session, whitelistId int64, purpose string) error {
+	_, err := tx.
+		Where("whitelist_id = ? AND purpose = ? AND used_at IS NULL AND expired_at > ?", whitelistId, purpose, time.Now()).
+		Cols("expired_at").
+		Update(&WhitelistToken{ExpiredAt: time.Now()})
+
+	return err
+}
+
+// sendToken issues a token and queues the email with it within the transaction.
+func (w *Whitelist) sendToken(tx *xorm.Session, purpose string, ttl time.Duration, build TokenEmail) (string, error) {
+	token, err := newToken(tx, w.Id, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	email, err := build(w, token)
+	if err != nil {
+		return "", err
+	}
+
+	return token, email.enqueue(tx)
+}
+
+// SendToken issues a token and queues the email with it, e.g. a link to download the data export.
+func (w *Whitelist) SendToken(purpose string, ttl time.Duration, build TokenEmail) error {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+
+	if _, err := w.sendToken(tx, purpose, ttl, build); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// NewToken issues a token of the purpose, e.g. TOKEN_QUESTION_REPLY.
+func (w *Whitelist) NewToken(purpose string, ttl time.Duration) (string, error) {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err := tx.Begin(); err != nil {
+		return "", err
+	}
+
+	token, err := newToken(tx, w.Id, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
 // CRUD
-func (wt *WhitelistToken) GetValidToken(token string) (has bool, err error) {
-	return db.Engine.Where("token = ? AND used_at IS NULL AND expired_at > ?", token, time.Now()).Get(wt)
+func (wt *WhitelistToken) GetValidToken(token string, purpose string) (has bool, err error) {
+	return db.Engine.
+		Where("token = ? AND purpose = ? AND used_at IS NULL AND expired_at > ?", token, purpose, time.Now()).
+		Get(wt)
 }
 
-func (wt *WhitelistToken) TokenConfirmed() (w *Whitelist, err error) {
+// use marks the token used, fails if it has been used concurrently
+func (wt *WhitelistToken) use(tx *xorm.Session) error {
+	wt.UsedAt = pq.NullTime{Time: time.Now(), Valid: true}
+	i, err := tx.ID(wt.Token).Where("used_at IS NULL").Cols("used_at").Update(wt)
+	if err != nil {
+		return err
+	}
+	if i == 0 {
+		return errors.New("Token has been used already")
+	}
+
+	return nil
+}
+
+func (wt *WhitelistToken) TokenConfirmed(ip string) (w *Whitelist, err error) {
 	tx := db.Engine.NewSession()
 	defer tx.Close()
 
@@ -35,8 +158,7 @@ func (wt *WhitelistToken) TokenConfirmed() (w *Whitelist, err error) {
 		return nil, err
 	}
 
-	wt.UsedAt = pq.NullTime{Time: time.Now(), Valid: true}
-	if _, err = tx.ID(wt.Token).Cols("used_at").Update(wt); err != nil {
+	if err = wt.use(tx); err != nil {
 		return nil, err
 	}
 
@@ -49,10 +171,77 @@ func (wt *WhitelistToken) TokenConfirmed() (w *Whitelist, err error) {
 		return nil, err
 	}
 
-	w.VerificationStage = STAGE_EMAIL_CONFIRMED
-	if _, err = tx.ID(w.Id).Cols("verification_stage").Update(w); err != nil {
+	change := StageChange{To: STAGE_EMAIL_CONFIRMED, Actor: ACTOR_APPLICANT, Ip: ip}
+	transition, err := w.changeStage(tx, change)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
 
-	return w, tx.Commit()
-}
\ No newline at end of file
+	transition.runHooks(w, change)
+
+	return w, nil
+}
+
+// QuestionReplied stores the reply with new documents and moves the application back to review.
+func (wt *WhitelistToken) QuestionReplied(reply QuestionReply, ip string) (w *Whitelist, err error) {
+	tx := db.Engine.NewSession()
+	defer tx.Close()
+
+	if err = tx.Begin(); err != nil {
+		return nil, err
+	}
+
+	if err = wt.use(tx); err != nil {
+		return nil, err
+	}
+
+	w = &Whitelist{}
+	has, err := tx.ID(wt.WhitelistId).Get(w)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, errors.New(fmt.Sprintf("Can't find whitelist with id: %v", wt.WhitelistId))
+	}
+
+	// replace only the documents sent with the reply
+	var cols []string
+	if reply.SelfieId.Valid {
+		w.SelfieId = reply.SelfieId
+		cols = append(cols, "selfie_id")
+	}
+	if reply.ResidentialPhotoId.Valid {
+		w.ResidentialPhotoId = reply.ResidentialPhotoId
+		cols = append(cols, "residential_photo_id")
+	}
+	if reply.StatementPhotoId.Valid {
+		w.StatementPhotoId = reply.StatementPhotoId
+		cols = append(cols, "statement_photo_id")
+	}
+	if len(cols) > 0 {
+		if _, err = tx.ID(w.Id).Cols(cols...).Update(w); err != nil {
+			return nil, err
+		}
+		if err = w.ownPhotos(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	change := StageChange{To: STAGE_EMAIL_CONFIRMED, Actor: ACTOR_APPLICANT, Ip: ip, Note: reply.Text}
+	transition, err := w.changeStage(tx, change)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	transition.runHooks(w, change)
+
+	return w, nil
+}
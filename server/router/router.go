@@ -8,8 +8,7 @@ import (
 	"../config"
 	"../controller"
 	controller_admin "../controller/admin"
-	"time"
-	"github.com/kataras/iris/middleware/basicauth"
+	"../model"
 )
 
 func Routes(app *iris.Application) {
@@ -32,23 +31,57 @@ func Routes(app *iris.Application) {
 	captchaRoute.Get("/{captcha}", controller.CaptchaMedia)
 
 	root.Get("/whitelist/confirm_email", controller.WhitelistConfirmEmail)
+	root.Post("/whitelist/resend_confirmation", controller.WhitelistResendConfirmation)
+	root.Post("/whitelist/data_request", controller.WhitelistDataRequest)
+	root.Get("/whitelist/data", controller.WhitelistData)
+	root.Get("/photo/{id:int min(1)}", controller_admin.GetSignedPhoto) // signed URLs for <img> tags of the admin UI
 	root.Post("/whitelist/request", iris.LimitRequestBodySize((config.Config.MaxFileUploadSizeMb*3)<<20), controller.WhitelistRequest)
+	root.Get("/whitelist/reply", controller.WhitelistReplyForm)
+	root.Post("/whitelist/reply", iris.LimitRequestBodySize((config.Config.MaxFileUploadSizeMb*3)<<20), controller.WhitelistReply)
 
-	// admin section
-	authConfig := basicauth.Config{
-		Users:   map[string]string{config.Config.AdminLogin: config.Config.AdminPassword},
-		Realm:   "Authorization Required", // defaults to "Authorization Required"
-		Expires: time.Duration(1) * time.Minute,
-	}
-
-	authentication := basicauth.New(authConfig)
+	// admin section, accounts are in the admins table
+	root.Post("/admin/login", controller_admin.Login)
+	root.Post("/admin/refresh", controller_admin.Refresh)
+	root.Post("/admin/logout", controller_admin.Logout) // the refresh token is the credential
 
-	admin := root.Party("/admin", authentication)
+	admin := root.Party("/admin", controller_admin.Authenticate)
 	{
+		// every route but those of the own account requires a permission, see model.RolePermissions
+		can := controller_admin.Require
+
 		admin.Get("/basic-auth", func(ctx iris.Context) {}) // to check auth
-		admin.Get("/whitelist/list", controller_admin.GetWhitelistList)
-		admin.Post("/whitelist/accept/{id:int min(1)}", controller_admin.WhitelistAccept)
-		admin.Post("/whitelist/decline/{id:int min(1)}", controller_admin.WhitelistDecline)
-		admin.Post("/whitelist/question/{id:int min(1)}", controller_admin.WhitelistQuestion)
+		admin.Get("/me", controller_admin.GetCurrentAdmin)
+		admin.Get("/totp", controller_admin.GetTotp)
+		admin.Post("/totp/setup", controller_admin.SetupTotp)
+		admin.Post("/totp/enable", controller_admin.EnableTotp)
+		admin.Post("/totp/disable", controller_admin.DisableTotp)
+		admin.Post("/totp/recovery_codes", controller_admin.NewRecoveryCodes)
+		admin.Get("/admins", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.GetAdminList)
+		admin.Post("/admins", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.CreateAdmin)
+		admin.Post("/admins/disable/{id:int min(1)}", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.DisableAdmin)
+		admin.Post("/admins/enable/{id:int min(1)}", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.EnableAdmin)
+		admin.Post("/admins/reset/{id:int min(1)}", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.ResetAdminPassword)
+		admin.Post("/admins/role/{id:int min(1)}", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.SetAdminRole)
+		admin.Post("/admins/reset_totp/{id:int min(1)}", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.ResetAdminTotp)
+		admin.Get("/lockouts", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.GetLockouts)
+		admin.Post("/lockouts/clear/{id:int min(1)}", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.ClearLockout)
+		admin.Get("/login_attempts", can(model.PERMISSION_ADMIN_MANAGE), controller_admin.GetLoginAttempts)
+		admin.Get("/whitelist/list", can(model.PERMISSION_WHITELIST_VIEW), controller_admin.GetWhitelistList)
+		admin.Get("/whitelist/export", can(model.PERMISSION_WHITELIST_EXPORT), controller_admin.GetWhitelistExport)
+		admin.Get("/whitelist/{id:int min(1)}", can(model.PERMISSION_WHITELIST_VIEW), controller_admin.GetWhitelist)
+		admin.Get("/whitelist/history/{id:int min(1)}", can(model.PERMISSION_WHITELIST_VIEW), controller_admin.GetWhitelistHistory)
+		admin.Get("/whitelist/reasons", can(model.PERMISSION_WHITELIST_VIEW), controller_admin.GetDecisionReasons)
+		admin.Post("/whitelist/next", can(model.PERMISSION_WHITELIST_CLAIM), controller_admin.WhitelistNext)
+		admin.Post("/whitelist/release/{id:int min(1)}", can(model.PERMISSION_WHITELIST_CLAIM), controller_admin.WhitelistRelease)
+		admin.Post("/whitelist/assign/{id:int min(1)}", can(model.PERMISSION_WHITELIST_ASSIGN), controller_admin.WhitelistAssign)
+		admin.Post("/whitelist/note/{id:int min(1)}", can(model.PERMISSION_WHITELIST_NOTE), controller_admin.WhitelistNote)
+		admin.Get("/photo/{id:int min(1)}", can(model.PERMISSION_WHITELIST_DOCUMENTS), controller_admin.GetPhoto)
+		admin.Post("/whitelist/accept/{id:int min(1)}", can(model.PERMISSION_WHITELIST_ACCEPT), controller_admin.WhitelistAccept)
+		admin.Post("/whitelist/decline/{id:int min(1)}", can(model.PERMISSION_WHITELIST_DECLINE), controller_admin.WhitelistDecline)
+		admin.Post("/whitelist/question/{id:int min(1)}", can(model.PERMISSION_WHITELIST_QUESTION), controller_admin.WhitelistQuestion)
+		admin.Post("/whitelist/erase/{id:int min(1)}", can(model.PERMISSION_WHITELIST_ERASE), controller_admin.WhitelistErase)
+		admin.Get("/whitelist/data/{id:int min(1)}", can(model.PERMISSION_WHITELIST_EXPORT), controller_admin.GetWhitelistData)
+		admin.Get("/email/list", can(model.PERMISSION_EMAIL_MANAGE), controller_admin.GetEmailList)
+		admin.Post("/email/resend/{id:int min(1)}", can(model.PERMISSION_EMAIL_MANAGE), controller_admin.ResendEmail)
 	}
 }
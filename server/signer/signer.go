@@ -0,0 +1,73 @@
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"../config"
+)
+
+var (
+	ErrNoKey     = errors.New("AppKey is not configured")
+	ErrExpired   = errors.New("signature has expired")
+	ErrSignature = errors.New("signature is invalid")
+)
+
+// URL returns path with params, an expiry and an HMAC signature keyed by AppKey.
+func URL(path string, params url.Values, ttl time.Duration) string {
+	signed := url.Values{}
+	for key, values := range params {
+		signed[key] = values
+	}
+	signed.Set("expires", strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+	signed.Set("signature", sign(path, signed))
+
+	return path + "?" + signed.Encode()
+}
+
+// Verify checks the signature and expiry of a URL made by URL.
+func Verify(path string, query url.Values) error {
+	if config.Config.AppKey == "" {
+		return ErrNoKey
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+
+	expected, err := hex.DecodeString(sign(path, query))
+	if err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || !hmac.Equal(signature, expected) {
+		return ErrSignature
+	}
+
+	if time.Now().Unix() > expires {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// sign covers the path and every parameter except the signature itself
+func sign(path string, query url.Values) string {
+	params := url.Values{}
+	for key, values := range query {
+		if key != "signature" {
+			params[key] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(config.Config.AppKey))
+	mac.Write([]byte(path + "?" + params.Encode()))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
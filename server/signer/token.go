@@ -0,0 +1,79 @@
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"../config"
+)
+
+// Claims is the payload of a signed token.
+type Claims struct {
+	Subject   int64  `json:"sub"`
+	Type      string `json:"typ"` // tokens of one type are rejected as another
+	Session   string `json:"sid,omitempty"`
+	Version   int64  `json:"ver"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Token returns the claims and an HMAC signature keyed by AppKey, both base64url encoded.
+func Token(claims Claims, ttl time.Duration) (string, error) {
+	if config.Config.AppKey == "" {
+		return "", ErrNoKey
+	}
+
+	claims.ExpiresAt = time.Now().Add(ttl).Unix()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signToken(encoded)), nil
+}
+
+// ParseToken checks the signature, type and expiry of a token made by Token.
+func ParseToken(token string, typ string) (*Claims, error) {
+	if config.Config.AppKey == "" {
+		return nil, ErrNoKey
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrSignature
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, signToken(parts[0])) {
+		return nil, ErrSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrSignature
+	}
+
+	claims := &Claims{}
+	if err = json.Unmarshal(payload, claims); err != nil || claims.Type != typ {
+		return nil, ErrSignature
+	}
+
+	if time.Now().Unix() > claims.ExpiresAt {
+		return claims, ErrExpired
+	}
+
+	return claims, nil
+}
+
+// signToken uses a prefix, so a token signature is never valid for a URL
+func signToken(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(config.Config.AppKey))
+	mac.Write([]byte("token:" + payload))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,45 @@
+package spreadsheet
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// Writer streams rows of text cells.
+type Writer interface {
+	Write(row []string) error
+	Close() error
+}
+
+// CSV writes comma separated values, cells are escaped against formula injection.
+type CSV struct {
+	w *csv.Writer
+}
+
+func NewCSV(w io.Writer) *CSV {
+	return &CSV{w: csv.NewWriter(w)}
+}
+
+func (c *CSV) Write(row []string) error {
+	escaped := make([]string, len(row))
+	for i, cell := range row {
+		escaped[i] = escapeFormula(cell)
+	}
+
+	return c.w.Write(escaped)
+}
+
+func (c *CSV) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// escapeFormula keeps spreadsheet applications from evaluating applicant input, e.g. "=HYPERLINK(...)".
+func escapeFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+
+	return cell
+}
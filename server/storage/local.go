@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local keeps blobs on the local file system below Root.
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) *Local {
+	if root == "" {
+		root = "./uploads"
+	}
+
+	return &Local{Root: root}
+}
+
+func (l *Local) path(key string) string {
+	// keys never leave the root
+	return filepath.Join(l.Root, filepath.FromSlash(filepath.Clean("/"+key)))
+}
+
+func (l *Local) Put(key string, r io.Reader) error {
+	path := l.path(key)
+
+	// identity documents are readable by the server user only
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return out.Close()
+}
+
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+
+	return f, err
+}
+
+func (l *Local) Delete(key string) error {
+	err := os.Remove(l.path(key))
+	if os.IsNotExist(err) {
+		return ErrNotExist
+	}
+
+	return err
+}
+
+func (l *Local) Stat(key string) (Info, error) {
+	fi, err := os.Stat(l.path(key))
+	if os.IsNotExist(err) {
+		return Info{}, ErrNotExist
+	}
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"../config"
+)
+
+// ErrNotExist is returned when a blob with the given key is absent.
+var ErrNotExist = errors.New("blob does not exist")
+
+// Info describes a stored blob.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore keeps uploaded files under slash separated keys, e.g. "abc/def/name.png".
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	Stat(key string) (Info, error)
+}
+
+var Store BlobStore
+
+func Init() (store BlobStore, err error) {
+	switch config.Config.StorageDriver {
+	case "s3":
+		store, err = NewS3(S3Options{
+			Endpoint:       config.Config.S3Endpoint,
+			Region:         config.Config.S3Region,
+			Bucket:         config.Config.S3Bucket,
+			Key:            config.Config.S3Key,
+			Secret:         config.Config.S3Secret,
+			ForcePathStyle: config.Config.S3ForcePathStyle,
+		})
+	case "local", "":
+		store = NewLocal(config.Config.StoragePath)
+	default:
+		return nil, errors.New("Unknown storage driver: " + config.Config.StorageDriver)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	Store = store
+
+	return Store, nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+type S3Options struct {
+	Endpoint       string // empty for AWS, e.g. http://minio:9000 otherwise
+	Region         string
+	Bucket         string
+	Key            string
+	Secret         string
+	ForcePathStyle bool // required by MinIO and most self-hosted services
+}
+
+// S3 keeps blobs in an S3 compatible bucket, so every server instance sees the same files.
+type S3 struct {
+	bucket   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+func NewS3(opts S3Options) (*S3, error) {
+	cfg := &aws.Config{
+		Region:           aws.String(opts.Region),
+		S3ForcePathStyle: aws.Bool(opts.ForcePathStyle),
+	}
+	if opts.Endpoint != "" {
+		cfg.Endpoint = aws.String(opts.Endpoint)
+	}
+	if opts.Key != "" {
+		cfg.Credentials = credentials.NewStaticCredentials(opts.Key, opts.Secret, "")
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.New(sess)
+
+	return &S3{
+		bucket:   opts.Bucket,
+		client:   client,
+		uploader: s3manager.NewUploaderWithClient(client),
+	}, nil
+}
+
+func (s *S3) Put(key string, r io.Reader) error {
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	})
+
+	return err
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return out.Body, nil
+}
+
+func (s *S3) Delete(key string) error {
+	// S3 does not report missing keys on delete
+	if _, err := s.Stat(key); err != nil {
+		return err
+	}
+
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	return s3Error(err)
+}
+
+func (s *S3) Stat(key string) (Info, error) {
+	out, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return Info{}, s3Error(err)
+	}
+
+	return Info{Key: key, Size: aws.Int64Value(out.ContentLength), ModTime: aws.TimeValue(out.LastModified)}, nil
+}
+
+func s3Error(err error) error {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return ErrNotExist
+	}
+
+	return err
+}
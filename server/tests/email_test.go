@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/httptest"
+
+	"../config"
+	"../email"
+	"../model"
+)
+
+func testMessage() email.Message {
+	return email.Message{
+		From:    "MDL <noreply@mdl.life>",
+		To:      "john@example.com",
+		Subject: "Whitelist application received",
+		Text:    "Your whitelist submission is well received.",
+		HTML:    "<h3>Your whitelist submission is well received.</h3>",
+		ReplyTo: "support@mdl.life",
+	}
+}
+
+func checkMessage(t *testing.T, raw string) {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Header.Get("Subject") != "Whitelist application received" {
+		t.Errorf("subject: %q", msg.Header.Get("Subject"))
+	}
+	if msg.Header.Get("Reply-To") != "support@mdl.life" {
+		t.Errorf("reply to: %q", msg.Header.Get("Reply-To"))
+	}
+	if !strings.HasPrefix(msg.Header.Get("Content-Type"), "multipart/alternative") {
+		t.Errorf("content type: %q", msg.Header.Get("Content-Type"))
+	}
+
+	body, _ := ioutil.ReadAll(msg.Body)
+	for _, part := range []string{"text/plain", "text/html", "well received."} {
+		if !strings.Contains(string(body), part) {
+			t.Errorf("body misses %q", part)
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	server, err := NewFakeSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Addr)
+	portNumber, _ := strconv.Atoi(port)
+	mailer := email.NewSMTP(email.SMTPOptions{Host: host, Port: portNumber})
+
+	id, err := mailer.Send(testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(id, "@mdl.life>") {
+		t.Errorf("message id: %q", id)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	if messages[0].From != "noreply@mdl.life" || len(messages[0].To) != 1 || messages[0].To[0] != "john@example.com" {
+		t.Errorf("envelope: %+v", messages[0])
+	}
+	checkMessage(t, messages[0].Data)
+
+	if _, err := mailer.Send(email.Message{From: "noreply@mdl.life", To: "not an address"}); err == nil {
+		t.Error("expected an error for an invalid recipient")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := email.NewFile(dir).Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, string(data))
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(m email.Message) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func TestEmailBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 20: 6 * time.Hour} {
+		if delay := email.Backoff(attempts); delay != expected {
+			t.Errorf("attempt %d: expected %s, got %s", attempts, expected, delay)
+		}
+	}
+}
+
+func TestEmailOutbox(t *testing.T) {
+	e := InitTestServer(t)
+	mailer := email.Mail
+	defer func() { email.Mail = mailer }()
+
+	// deliver whatever earlier tests queued
+	if _, err := email.Deliver(); err != nil {
+		t.Fatal(err)
+	}
+
+	whitelist, _ := createWhitelist(t)
+	config.Config.MailMaxAttempts = 2
+	defer func() { config.Config.MailMaxAttempts = 0 }()
+
+	email.Mail = failingMailer{}
+	if _, err := email.Deliver(); err != nil {
+		t.Fatal(err)
+	}
+
+	emails, err := model.GetEmails(whitelist.Id)
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("expected the confirmation email, got %v %v", emails, err)
+	}
+	if emails[0].Status != model.EMAIL_PENDING || emails[0].Attempts != 1 || emails[0].Error != "connection refused" {
+		t.Errorf("first attempt: %+v", emails[0])
+	}
+	if !emails[0].NextAttemptAt.After(time.Now()) {
+		t.Errorf("next attempt is not delayed: %s", emails[0].NextAttemptAt)
+	}
+
+	// the second attempt is the last one
+	emails[0].Undelivered(errors.New("connection refused"), time.Time{})
+
+	failed := e.GET("/admin/email/list").WithQuery("status", "failed").
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Array()
+	failed.Length().Equal(1)
+	failed.Element(0).Object().ValueEqual("WhitelistId", whitelist.Id).ValueEqual("Attempts", 2)
+
+	emailId := strconv.FormatInt(emails[0].Id, 10)
+	email.Mail = mailer
+	e.POST("/admin/email/resend/"+emailId).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object().
+		ValueEqual("Status", model.EMAIL_SENT).ValueEqual("Attempts", 1)
+
+	e.POST("/admin/email/resend/"+emailId).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusConflict)
+}
+
+func TestEmailTemplates(t *testing.T) {
+	templates := email.Templates
+	defer func() { email.Templates = templates }()
+
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "en"), 0700)
+	os.MkdirAll(filepath.Join(dir, "de"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "en", "declined.subject.txt"), []byte("Declined\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "en", "declined.txt"), []byte("{{.Message}}"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "en", "declined.html"), []byte("{{range .MessageLines}}{{.}}<br>{{end}}"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "de", "declined.subject.txt"), []byte("Abgelehnt"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "de", "declined.txt"), []byte("{{.Message}}"), 0600)
+
+	if email.Templates, err = email.LoadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for language, expected := range map[string]string{"de-DE": "de", "DE": "de", "fr": "en", "": "en"} {
+		if email.Language(language) != expected {
+			t.Errorf("%q: expected %s, got %s", language, expected, email.Language(language))
+		}
+	}
+
+	m, err := email.Render("de", "declined", email.TemplateData{Message: "Der Pass ist abgelaufen."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Abgelehnt" || m.Text != "Der Pass ist abgelaufen." || m.HTML != "" {
+		t.Errorf("de: %+v", m)
+	}
+
+	m, err = email.Render("en", "declined", email.TemplateData{Message: "<b>expired</b>\nsorry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Declined" || m.HTML != "&lt;b&gt;expired&lt;/b&gt;<br>sorry<br>" {
+		t.Errorf("en: %+v", m)
+	}
+
+	if _, err := email.Render("en", "unknown", email.TemplateData{}); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+
+	// the bundled templates
+	if email.Templates, err = email.LoadTemplates("../templates/email"); err != nil {
+		t.Fatal(err)
+	}
+	config.Config.PublicUrl = "https://example.com/"
+	defer func() { config.Config.PublicUrl = "" }()
+
+	m, err = email.Render("en", "confirm_email", email.TemplateData{Link: email.PublicURL("/whitelist/confirm_email?token=abc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Text, "following the link\nhttps://example.com/whitelist/confirm_email?token=abc\n") {
+		t.Errorf("text: %s", m.Text)
+	}
+	if !strings.Contains(m.HTML, `<a href="https://example.com/whitelist/confirm_email?token=abc">`) {
+		t.Errorf("html: %s", m.HTML)
+	}
+}
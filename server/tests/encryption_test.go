@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"../encryption"
+	"../utils"
+)
+
+func newMasterKey(t *testing.T) *encryption.MasterKey {
+	key, err := encryption.NewMasterKey(utils.SecureRandomBytes(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestEnvelopeEncryption(t *testing.T) {
+	oldKey, newKey := newMasterKey(t), newMasterKey(t)
+	content := []byte("passport scan")
+
+	keys := encryption.NewKeyring(oldKey)
+	sealed, wrappedKey, keyId, err := keys.Seal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, content) || keyId != oldKey.Id {
+		t.Fatalf("content is not sealed with the current key")
+	}
+
+	data, err := keys.Open(sealed, wrappedKey, keyId)
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("Open: expected %q, got %q (%v)", content, data, err)
+	}
+
+	// rotation keeps the blob and re-wraps the data key only
+	rotated := encryption.NewKeyring(newKey, oldKey)
+	wrappedKey, keyId, err = rotated.Rewrap(wrappedKey, keyId)
+	if err != nil || keyId != newKey.Id {
+		t.Fatalf("Rewrap: %v", err)
+	}
+
+	if _, err := encryption.NewKeyring(newKey).Open(sealed, wrappedKey, keyId); err != nil {
+		t.Errorf("Open after rotation: %v", err)
+	}
+	if _, err := keys.Open(sealed, wrappedKey, keyId); err != encryption.ErrUnknownKey {
+		t.Errorf("Open with a retired keyring: expected ErrUnknownKey, got %v", err)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := rotated.Open(sealed, wrappedKey, keyId); err == nil {
+		t.Errorf("Open of tampered data must fail")
+	}
+}
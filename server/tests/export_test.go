@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"image/png"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/httptest"
+
+	"../config"
+	"../db"
+	"../model"
+)
+
+// readArchive returns the files of a ZIP by name
+func readArchive(t *testing.T, body string) map[string][]byte {
+	r, err := zip.NewReader(bytes.NewReader([]byte(body)), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = ioutil.ReadAll(rc)
+		rc.Close()
+	}
+
+	return files
+}
+
+func TestWhitelistDataExport(t *testing.T) {
+	e := InitTestServer(t)
+	defer useFixedCaptcha()()
+	clearRateLimits(t)
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+	e.POST("/admin/whitelist/note/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		WithJSON(map[string]string{"note": "internal note"}).Expect().Status(httptest.StatusOK)
+
+	response := e.GET("/admin/whitelist/data/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).ContentType("application/zip")
+	files := readArchive(t, response.Body().Raw())
+
+	var data model.WhitelistExport
+	if err := json.Unmarshal(files["data.json"], &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Whitelist.Email != whitelist.Email || len(data.StageEvents) != 1 || len(data.Emails) != 1 || len(data.Documents) != 2 ||
+		len(data.Notes) != 1 {
+		t.Errorf("data.json: %+v", data)
+	}
+	for _, name := range []string{"documents/passport.png", "documents/selfie.png"} {
+		if _, err := png.Decode(bytes.NewReader(files[name])); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// the applicant receives a link by email
+	e.POST("/whitelist/data_request").
+		WithFormField("email", whitelist.Email).
+		WithFormField("captchaId", "id").
+		WithFormField("captchaSolution", "123456").
+		Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("success", true)
+
+	exportToken := &model.WhitelistToken{}
+	has, err := db.Engine.Where("whitelist_id = ? AND purpose = ?", whitelist.Id, model.TOKEN_DATA_EXPORT).Get(exportToken)
+	if err != nil || !has {
+		t.Fatalf("no data export token %v", err)
+	}
+
+	response = e.GET("/whitelist/data").WithQuery("token", exportToken.Token).Expect().
+		Status(httptest.StatusOK).ContentType("application/zip")
+	if files = readArchive(t, response.Body().Raw()); files["documents/passport.png"] == nil {
+		t.Error("the applicant archive misses the passport")
+	}
+
+	// the applicant's copy leaves out internal notes and who changed the stage
+	data = model.WhitelistExport{}
+	if err := json.Unmarshal(files["data.json"], &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Notes) != 0 || len(data.StageEvents) != 1 || data.StageEvents[0].Actor != "" || data.StageEvents[0].Ip != "" {
+		t.Errorf("applicant data.json: %+v", data)
+	}
+
+	e.GET("/whitelist/data").WithQuery("token", token).Expect().Status(httptest.StatusOK).
+		Body().Contains("This link has expired")
+}
+
+func TestWhitelistExport(t *testing.T) {
+	e := InitTestServer(t)
+	whitelist, token := createWhitelist(t)
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+
+	response := e.GET("/admin/whitelist/export").WithQuery("stage", "confirmed").
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).ContentType("text/csv")
+	rows, err := csv.NewReader(strings.NewReader(response.Body().Raw())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := strconv.FormatInt(whitelist.Id, 10)
+	found := false
+	for _, row := range rows[1:] {
+		if row[0] == id {
+			found = row[2] == whitelist.Email && row[11] != ""
+		}
+	}
+	if rows[0][0] != "Id" || !found {
+		t.Errorf("whitelist id: %v is missing in the export %q", id, rows)
+	}
+
+	response = e.GET("/admin/whitelist/export").WithQuery("format", "xlsx").
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+	if files := readArchive(t, response.Body().Raw()); files["xl/worksheets/sheet1.xml"] == nil {
+		t.Error("xlsx export has no sheet")
+	}
+
+	e.GET("/admin/whitelist/export").WithQuery("format", "pdf").
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusUnprocessableEntity)
+}
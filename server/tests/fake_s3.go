@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// FakeS3 is an in-memory stand-in for an S3 compatible service, path style addressing only.
+type FakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+func NewFakeS3Server() (*httptest.Server, *FakeS3) {
+	fake := &FakeS3{objects: map[string]fakeObject{}}
+
+	return httptest.NewServer(fake), fake
+}
+
+func (f *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// "/bucket/key" is stored as is
+	path := r.URL.Path
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[path] = fakeObject{data: data, modTime: time.Now().UTC()}
+		w.Header().Set("ETag", etag(data))
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+			}
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		w.Header().Set("ETag", etag(obj.data))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Len returns the number of stored objects.
+func (f *FakeS3) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.objects)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
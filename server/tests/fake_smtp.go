@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+)
+
+// FakeMessage is an envelope and the raw data received by FakeSMTP.
+type FakeMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// FakeSMTP is a local stand-in for a mail relay, it accepts every message without authentication.
+type FakeSMTP struct {
+	Addr     string
+	listener net.Listener
+	mu       sync.Mutex
+	messages []FakeMessage
+}
+
+func NewFakeSMTPServer() (*FakeSMTP, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FakeSMTP{Addr: l.Addr().String(), listener: l}
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *FakeSMTP) Close() error {
+	return s.listener.Close()
+}
+
+func (s *FakeSMTP) Messages() []FakeMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]FakeMessage(nil), s.messages...)
+}
+
+func (s *FakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *FakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	var msg FakeMessage
+	reply("220 localhost fake smtp")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg = FakeMessage{From: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.To = append(msg.To, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data []string
+			for {
+				dl, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				dl = strings.TrimRight(dl, "\r\n")
+				if dl == "." {
+					break
+				}
+				data = append(data, strings.TrimPrefix(dl, "."))
+			}
+			msg.Data = strings.Join(data, "\r\n")
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"../config"
+	"../imaging"
+)
+
+func pngBytes(width int, height int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+
+	return buf.Bytes()
+}
+
+func jpegBytes(width int, height int) []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil)
+
+	return buf.Bytes()
+}
+
+func TestImagingRead(t *testing.T) {
+	config.Config.MinImageDimension = 100
+	config.Config.MaxImageMegapixels = 1
+	defer func() {
+		config.Config.MinImageDimension = 0
+		config.Config.MaxImageMegapixels = 0
+	}()
+
+	valid := []struct {
+		filename string
+		data     []byte
+		format   imaging.Format
+	}{
+		{"passport.PNG", pngBytes(200, 100), imaging.PNG},
+		{"passport.jpg", jpegBytes(100, 200), imaging.JPEG},
+		{"passport.jpeg", jpegBytes(100, 200), imaging.JPEG},
+		{"passport.pdf", []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\n%%EOF\n"), imaging.PDF},
+	}
+	for _, v := range valid {
+		upload, err := imaging.Read(bytes.NewReader(v.data), v.filename)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", v.filename, err)
+			continue
+		}
+		if upload.Format != v.format {
+			t.Errorf("%s: expected format %s, got %s", v.filename, v.format, upload.Format)
+		}
+	}
+
+	invalid := []struct {
+		filename string
+		data     []byte
+	}{
+		{"passport", pngBytes(200, 200)},                // no extension
+		{"passport.gif", pngBytes(200, 200)},            // unsupported extension
+		{"passport.jpg", pngBytes(200, 200)},            // extension does not match
+		{"passport.png", []byte("<html></html>")},       // not an image
+		{"passport.png", pngBytes(200, 200)[:60]},       // truncated
+		{"passport.png", pngBytes(50, 200)},             // too small
+		{"passport.png", pngBytes(1001, 1000)},          // too many megapixels
+		{"passport.pdf", []byte("%PDF-1.4\n1 0 obj\n")}, // no end of file marker
+	}
+	for _, v := range invalid {
+		_, err := imaging.Read(bytes.NewReader(v.data), v.filename)
+		if _, ok := err.(imaging.ErrInvalid); !ok {
+			t.Errorf("%s: expected ErrInvalid, got %v", v.filename, err)
+		}
+	}
+}
+
+// withOrientation inserts an EXIF segment with the orientation tag after SOI
+func withOrientation(data []byte, orientation byte) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, 0, 0, 0, 0, 0, 0}
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	segment := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestImagingReencode(t *testing.T) {
+	data := withOrientation(jpegBytes(200, 100), 6)
+
+	upload, err := imaging.Read(bytes.NewReader(data), "selfie.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Reencode(); err != nil {
+		t.Fatal(err)
+	}
+
+	if upload.Width != 100 || upload.Height != 200 {
+		t.Errorf("expected the image rotated to 100x200, got %vx%v", upload.Width, upload.Height)
+	}
+	if bytes.Contains(upload.Data, []byte("Exif")) {
+		t.Errorf("EXIF data is not stripped")
+	}
+	if upload.OriginalSize != int64(len(data)) || len(upload.OriginalHash) != 64 {
+		t.Errorf("original size and hash are not kept")
+	}
+}
+
+func TestImagingThumbnail(t *testing.T) {
+	upload, err := imaging.Read(bytes.NewReader(pngBytes(1000, 500)), "passport.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumb, err := upload.Thumbnail()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("expected a JPEG thumbnail, got %s (%v)", format, err)
+	}
+	if cfg.Width != imaging.ThumbnailSize || cfg.Height != imaging.ThumbnailSize/2 {
+		t.Errorf("expected %vx%v, got %vx%v", imaging.ThumbnailSize, imaging.ThumbnailSize/2, cfg.Width, cfg.Height)
+	}
+}
@@ -2,9 +2,17 @@ package tests
 
 import (
 	"github.com/kataras/iris/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"../config"
+	"../db"
+	"../encryption"
+	"../model"
+	"../totp"
+	"../utils"
 )
 
 func TestAdminAuth(t *testing.T) {
@@ -23,3 +31,256 @@ func TestAdminAuth(t *testing.T) {
 	e.GET("/admin/whitelist/list").WithBasicAuth("invalidusername", "invalidpassword").
 		Expect().Status(httptest.StatusUnauthorized)
 }
+
+func TestAdminAccounts(t *testing.T) {
+	e := InitTestServer(t)
+	login := "reviewer" + utils.RandomString(8)
+
+	e.POST("/admin/admins").WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		WithJSON(map[string]string{"login": login, "password": "short"}).
+		Expect().Status(httptest.StatusUnprocessableEntity)
+
+	id := e.POST("/admin/admins").WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		WithJSON(map[string]string{"login": login, "password": "first password", "role": "reviewer"}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("Id").Number().Raw()
+	path := strconv.FormatInt(int64(id), 10)
+
+	e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": "wrong password"}).
+		Expect().Status(httptest.StatusUnauthorized)
+
+	token := e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": "first password", "role": "reviewer"}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("accessToken").String().Raw()
+	e.GET("/admin/whitelist/list").WithHeader("Authorization", "Bearer "+token).Expect().Status(httptest.StatusOK)
+
+	// a reset ends sessions and the old password
+	password := e.POST("/admin/admins/reset/"+path).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("password").String().Raw()
+	e.GET("/admin/whitelist/list").WithHeader("Authorization", "Bearer "+token).Expect().Status(httptest.StatusUnauthorized)
+	e.GET("/admin/whitelist/list").WithBasicAuth(login, "first password").Expect().Status(httptest.StatusUnauthorized)
+	e.GET("/admin/whitelist/list").WithBasicAuth(login, password).Expect().Status(httptest.StatusOK)
+
+	// disabled admins can't log in, nobody can disable themselves
+	e.POST("/admin/admins/disable/"+path).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		Expect().Status(httptest.StatusOK)
+	e.GET("/admin/whitelist/list").WithBasicAuth(login, password).Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": password}).
+		Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/admin/admins/enable/"+path).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		Expect().Status(httptest.StatusOK)
+	e.POST("/admin/admins/disable/"+path).WithBasicAuth(login, password).Expect().Status(httptest.StatusConflict)
+}
+
+// newTestAdmin creates an admin with the role, the password is the login
+func newTestAdmin(t *testing.T, role string) string {
+	login := role + utils.RandomString(8)
+	if _, err := model.NewAdmin(login, login, role); err != nil {
+		t.Fatal(err)
+	}
+
+	return login
+}
+
+func TestAdminPermissions(t *testing.T) {
+	e := InitTestServer(t)
+	defer setReasons(otherReason, otherReason)()
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+
+	viewer := newTestAdmin(t, model.ROLE_VIEWER)
+	reviewer := newTestAdmin(t, model.ROLE_REVIEWER)
+	approver := newTestAdmin(t, model.ROLE_APPROVER)
+
+	// viewers list applications without documents
+	passport := e.GET("/admin/whitelist/"+id).WithBasicAuth(viewer, viewer).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("Passport").Object()
+	passport.Value("Src").String().Empty()
+	e.GET("/admin/photo/"+strconv.FormatInt(whitelist.PassportId, 10)).WithBasicAuth(viewer, viewer).Expect().
+		Status(httptest.StatusForbidden).JSON().Object().Value("errors").Object().ValueEqual("permission", "whitelist.documents")
+
+	decision := map[string]interface{}{"reason": "other", "note": "Please upload a sharper photo", "shareWithApplicant": true}
+	e.POST("/admin/whitelist/question/"+id).WithBasicAuth(viewer, viewer).WithJSON(decision).Expect().
+		Status(httptest.StatusForbidden).JSON().Object().Value("errors").Object().ValueEqual("permission", "whitelist.question")
+
+	// reviewers decide everything but the acceptance
+	e.POST("/admin/whitelist/accept/"+id).WithBasicAuth(reviewer, reviewer).Expect().
+		Status(httptest.StatusForbidden).JSON().Object().Value("errors").Object().ValueEqual("permission", "whitelist.accept")
+	e.POST("/admin/whitelist/question/"+id).WithBasicAuth(reviewer, reviewer).WithJSON(decision).Expect().
+		Status(httptest.StatusOK)
+
+	// approvers accept, exports and accounts are left to superadmins
+	e.GET("/admin/whitelist/export").WithBasicAuth(approver, approver).Expect().
+		Status(httptest.StatusForbidden).JSON().Object().Value("errors").Object().ValueEqual("permission", "whitelist.export")
+	e.GET("/admin/admins").WithBasicAuth(approver, approver).Expect().
+		Status(httptest.StatusForbidden).JSON().Object().Value("errors").Object().ValueEqual("permission", "admin.manage")
+	e.POST("/admin/whitelist/accept/"+id).WithBasicAuth(approver, approver).Expect().Status(httptest.StatusOK)
+
+	// roles are changed by superadmins
+	viewerAdmin, _, _ := model.GetAdminByLogin(viewer)
+	e.POST("/admin/admins/role/"+strconv.FormatInt(viewerAdmin.Id, 10)).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		WithJSON(map[string]string{"role": "owner"}).Expect().Status(httptest.StatusUnprocessableEntity)
+	e.POST("/admin/admins/role/"+strconv.FormatInt(viewerAdmin.Id, 10)).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		WithJSON(map[string]string{"role": model.ROLE_REVIEWER}).Expect().Status(httptest.StatusOK)
+	e.GET("/admin/photo/"+strconv.FormatInt(whitelist.PassportId, 10)).WithBasicAuth(viewer, viewer).Expect().
+		Status(httptest.StatusOK)
+}
+
+func TestAdminTotp(t *testing.T) {
+	e := InitTestServer(t)
+	login := newTestAdmin(t, model.ROLE_VIEWER)
+
+	keys := encryption.Keys
+	encryption.Keys = encryption.NewKeyring(newMasterKey(t))
+	defer func() { encryption.Keys = keys }()
+
+	token := e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": login}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("accessToken").String().Raw()
+	bearer := "Bearer " + token
+
+	secret := e.POST("/admin/totp/setup").WithHeader("Authorization", bearer).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("secret").String().Raw()
+
+	// the secret is stored encrypted like the uploads
+	admin, _, err := model.GetAdminByLogin(login)
+	if err != nil || admin.TotpSecret == secret || admin.TotpKeyId == "" {
+		t.Errorf("the TOTP secret is stored in plain text %v", err)
+	}
+
+	e.POST("/admin/totp/enable").WithHeader("Authorization", bearer).WithJSON(map[string]string{"code": "000000x"}).
+		Expect().Status(httptest.StatusUnprocessableEntity)
+
+	// enrolment consumes the code of the previous step, so the current one is left for the login
+	previous, _ := totp.Code(secret, totp.Step(time.Now())-1)
+	codes := e.POST("/admin/totp/enable").WithHeader("Authorization", bearer).WithJSON(map[string]string{"code": previous}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("recoveryCodes").Array()
+	codes.Length().Equal(10)
+	recovery := codes.Element(0).String().Raw()
+
+	// the password alone is not enough anymore
+	e.GET("/admin/whitelist/list").WithBasicAuth(login, login).Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": login}).
+		Expect().Status(httptest.StatusUnauthorized).JSON().Object().ValueEqual("totpRequired", true)
+
+	current, _ := totp.Code(secret, totp.Step(time.Now()))
+	e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": login, "code": current}).
+		Expect().Status(httptest.StatusOK)
+	e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": login, "code": current}).
+		Expect().Status(httptest.StatusUnauthorized)
+
+	// recovery codes work once
+	e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": login, "code": strings.ToUpper(recovery)}).
+		Expect().Status(httptest.StatusOK)
+	e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": login, "code": recovery}).
+		Expect().Status(httptest.StatusUnauthorized)
+
+	// enforced two-factor authentication leaves admins without it the enrolment only
+	other := newTestAdmin(t, model.ROLE_VIEWER)
+	config.Config.AdminTotpRequired = true
+	defer func() { config.Config.AdminTotpRequired = false }()
+
+	e.GET("/admin/whitelist/list").WithBasicAuth(other, other).Expect().
+		Status(httptest.StatusForbidden).JSON().Object().Value("errors").Object().ContainsKey("totp")
+	e.POST("/admin/totp/setup").WithBasicAuth(other, other).Expect().Status(httptest.StatusOK)
+	e.GET("/admin/whitelist/list").WithHeader("Authorization", bearer).Expect().Status(httptest.StatusOK)
+}
+
+func TestAdminTokens(t *testing.T) {
+	e := InitTestServer(t)
+	login := newTestAdmin(t, model.ROLE_VIEWER)
+
+	session := e.POST("/admin/login").WithJSON(map[string]string{"login": login, "password": login}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Object()
+	session.Value("permissions").Array().Contains("whitelist.view")
+	access := session.Value("accessToken").String().Raw()
+	refresh := session.Value("refreshToken").String().Raw()
+
+	e.GET("/admin/me").WithHeader("Authorization", "Bearer "+access).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("admin").Object().ValueEqual("Login", login)
+
+	// a refresh token is no access token and works once
+	e.GET("/admin/me").WithHeader("Authorization", "Bearer "+refresh).Expect().Status(httptest.StatusUnauthorized)
+	refreshed := e.POST("/admin/refresh").WithJSON(map[string]string{"refreshToken": refresh}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Object()
+	e.POST("/admin/refresh").WithJSON(map[string]string{"refreshToken": refresh}).
+		Expect().Status(httptest.StatusUnauthorized)
+	access = refreshed.Value("accessToken").String().Raw()
+	refresh = refreshed.Value("refreshToken").String().Raw()
+	e.GET("/admin/me").WithHeader("Authorization", "Bearer "+access).Expect().Status(httptest.StatusOK)
+
+	// tampered tokens are rejected
+	e.GET("/admin/me").WithHeader("Authorization", "Bearer "+access[:len(access)-2]+"xx").Expect().
+		Status(httptest.StatusUnauthorized)
+
+	// logout revokes the refresh token
+	e.POST("/admin/logout").WithJSON(map[string]string{"refreshToken": refresh}).Expect().Status(httptest.StatusOK)
+	e.POST("/admin/refresh").WithJSON(map[string]string{"refreshToken": refresh}).
+		Expect().Status(httptest.StatusUnauthorized)
+
+	// revoking every session rejects the issued access tokens at once
+	admin, _, _ := model.GetAdminByLogin(login)
+	if err := admin.RevokeSessions(); err != nil {
+		t.Fatal(err)
+	}
+	e.GET("/admin/me").WithHeader("Authorization", "Bearer "+access).Expect().Status(httptest.StatusUnauthorized)
+}
+
+func TestAdminLockout(t *testing.T) {
+	e := InitTestServer(t)
+	login := newTestAdmin(t, model.ROLE_VIEWER)
+
+	maxFailures := config.Config.AdminMaxLoginFailures
+	config.Config.AdminMaxLoginFailures = 4
+	defer func() { config.Config.AdminMaxLoginFailures = maxFailures }()
+
+	wrong := map[string]string{"login": login, "password": "wrong password"}
+	right := map[string]string{"login": login, "password": login}
+
+	// the second failure delays the next attempt
+	e.POST("/admin/login").WithJSON(wrong).Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/admin/login").WithJSON(wrong).Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/admin/login").WithJSON(right).Expect().Status(httptest.StatusTooManyRequests).Header("Retry-After").NotEmpty()
+	e.GET("/admin/whitelist/list").WithBasicAuth(login, login).Expect().Status(httptest.StatusTooManyRequests)
+
+	// skip the delays, the fourth failure locks the login out
+	for i := 0; i < 2; i++ {
+		if _, err := db.Engine.Exec("UPDATE admin_lockouts SET next_attempt_at = ? WHERE subject = ?", time.Now().Add(-time.Second), login); err != nil {
+			t.Fatal(err)
+		}
+		e.POST("/admin/login").WithJSON(wrong).Expect().Status(httptest.StatusUnauthorized)
+	}
+	if _, err := db.Engine.Exec("UPDATE admin_lockouts SET next_attempt_at = ? WHERE subject = ?", time.Now().Add(-time.Second), login); err != nil {
+		t.Fatal(err)
+	}
+	e.POST("/admin/login").WithJSON(right).Expect().Status(httptest.StatusTooManyRequests)
+
+	// superadmins see and clear lockouts
+	lockouts := e.GET("/admin/lockouts").WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Array()
+	var lockoutId int64
+	for _, value := range lockouts.Iter() {
+		lockout := value.Object()
+		if lockout.Value("Subject").String().Raw() == login {
+			lockout.ValueEqual("Failures", 4)
+			lockoutId = int64(lockout.Value("Id").Number().Raw())
+		}
+	}
+	if lockoutId == 0 {
+		t.Fatalf("no lockout of %s", login)
+	}
+
+	e.POST("/admin/lockouts/clear/"+strconv.FormatInt(lockoutId, 10)).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().Status(httptest.StatusOK)
+	e.POST("/admin/login").WithJSON(right).Expect().Status(httptest.StatusOK)
+
+	// every attempt is in the audit log
+	attempts := e.GET("/admin/login_attempts").WithQuery("login", login).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).
+		Expect().Status(httptest.StatusOK).JSON().Object()
+	attempts.Value("pagination").Object().ValueEqual("rowsNumber", 8)
+	attempts.Value("data").Array().Element(0).Object().ValueEqual("Success", true).ValueEqual("Reason", "success")
+
+	// the failures of the test IP are forgotten, other tests log in from it too
+	db.Engine.Where("kind = ?", model.LOCKOUT_IP).Delete(&model.AdminLockout{})
+}
@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/httptest"
+
+	"../config"
+	controller_admin "../controller/admin"
+	"../imaging"
+	"../model"
+)
+
+func TestAdminPhoto(t *testing.T) {
+	e := InitTestServer(t)
+
+	upload, err := imaging.Read(bytes.NewReader(pngBytes(800, 600)), "passport.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := &model.Photo{}
+	if err := photo.StoreFile(upload); err != nil {
+		t.Fatal(err)
+	}
+	url := "/admin/photo/" + strconv.FormatInt(photo.Id, 10)
+
+	e.GET(url).Expect().Status(httptest.StatusUnauthorized)
+
+	full := e.GET(url).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+	full.Header("Content-Type").Equal("image/png")
+	etag := full.Header("ETag").NotEmpty().Raw()
+
+	e.GET(url).WithQuery("size", "thumb").WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).Header("Content-Type").Equal("image/jpeg")
+
+	e.GET(url).WithHeader("Range", "bytes=0-9").WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusPartialContent).Header("Content-Length").Equal("10")
+
+	e.GET(url).WithHeader("If-None-Match", etag).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(304)
+
+	e.GET(url).WithQuery("size", "huge").WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusUnprocessableEntity)
+
+	// signed URLs work without basic auth
+	signed := controller_admin.SignedPhotoURL(photo.Id, "thumb", config.Config.AdminLogin)
+	e.GET(signed).Expect().Status(httptest.StatusOK).Header("Content-Type").Equal("image/jpeg")
+	e.GET(strings.Replace(signed, "size=thumb", "size=full", 1)).Expect().Status(httptest.StatusForbidden)
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"../config"
+	"../signer"
+)
+
+func TestSignedURL(t *testing.T) {
+	appKey := config.Config.AppKey
+	config.Config.AppKey = "secret"
+	defer func() { config.Config.AppKey = appKey }()
+
+	verify := func(signed string) error {
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer.Verify(u.Path, u.Query())
+	}
+
+	signed := signer.URL("/photo/1", url.Values{"size": {"full"}, "admin": {"alice"}}, time.Minute)
+	if err := verify(signed); err != nil {
+		t.Errorf("valid URL: %v", err)
+	}
+
+	if err := verify(strings.Replace(signed, "alice", "mallory", 1)); err != signer.ErrSignature {
+		t.Errorf("tampered admin: expected ErrSignature, got %v", err)
+	}
+	if err := verify(strings.Replace(signed, "/photo/1", "/photo/2", 1)); err != signer.ErrSignature {
+		t.Errorf("tampered path: expected ErrSignature, got %v", err)
+	}
+
+	expired := signer.URL("/photo/1", url.Values{"size": {"full"}}, -time.Minute)
+	if err := verify(expired); err != signer.ErrExpired {
+		t.Errorf("expired URL: expected ErrExpired, got %v", err)
+	}
+
+	config.Config.AppKey = "another"
+	if err := verify(signed); err != signer.ErrSignature {
+		t.Errorf("other AppKey: expected ErrSignature, got %v", err)
+	}
+
+	config.Config.AppKey = ""
+	if err := verify(signed); err != signer.ErrNoKey {
+		t.Errorf("no AppKey: expected ErrNoKey, got %v", err)
+	}
+}
+
+func TestSignedToken(t *testing.T) {
+	appKey := config.Config.AppKey
+	config.Config.AppKey = "secret"
+	defer func() { config.Config.AppKey = appKey }()
+
+	token, err := signer.Token(signer.Claims{Subject: 7, Type: "access", Version: 2}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := signer.ParseToken(token, "access")
+	if err != nil || claims.Subject != 7 || claims.Version != 2 {
+		t.Errorf("valid token: %+v %v", claims, err)
+	}
+
+	if _, err := signer.ParseToken(token, "refresh"); err != signer.ErrSignature {
+		t.Errorf("other type: expected ErrSignature, got %v", err)
+	}
+
+	expired, _ := signer.Token(signer.Claims{Subject: 7, Type: "access"}, -time.Minute)
+	if _, err := signer.ParseToken(expired, "access"); err != signer.ErrExpired {
+		t.Errorf("expired: expected ErrExpired, got %v", err)
+	}
+
+	config.Config.AppKey = "another"
+	if _, err := signer.ParseToken(token, "access"); err != signer.ErrSignature {
+		t.Errorf("other AppKey: expected ErrSignature, got %v", err)
+	}
+
+	config.Config.AppKey = ""
+	if _, err := signer.Token(signer.Claims{Subject: 7, Type: "access"}, time.Minute); err != signer.ErrNoKey {
+		t.Errorf("no AppKey: expected ErrNoKey, got %v", err)
+	}
+}
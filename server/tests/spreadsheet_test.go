@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"../spreadsheet"
+)
+
+func TestSpreadsheetCSV(t *testing.T) {
+	var buf bytes.Buffer
+	sheet := spreadsheet.NewCSV(&buf)
+	sheet.Write([]string{"Name", "Note"})
+	sheet.Write([]string{"=HYPERLINK(\"http://example.com\")", "-1+1"})
+	sheet.Write([]string{"Alice, Bob", "plain"})
+	if err := sheet.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[1][0] != "'=HYPERLINK(\"http://example.com\")" || rows[1][1] != "'-1+1" {
+		t.Errorf("formulas are not escaped: %q", rows[1])
+	}
+	if rows[2][0] != "Alice, Bob" || rows[2][1] != "plain" {
+		t.Errorf("unexpected row: %q", rows[2])
+	}
+}
+
+func TestSpreadsheetXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	sheet, err := spreadsheet.NewXLSX(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet.Write([]string{"Name", "Email"})
+	sheet.Write([]string{"Tom & <Jerry>", "tom@example.com"})
+	if err := sheet.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readArchive(t, buf.String())
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if files[name] == nil {
+			t.Errorf("missing part %s", name)
+		}
+	}
+
+	data := string(files["xl/worksheets/sheet1.xml"])
+	if !strings.Contains(data, `<c r="B2" t="inlineStr"><is><t xml:space="preserve">tom@example.com</t></is></c>`) ||
+		!strings.Contains(data, "Tom &amp; &lt;Jerry&gt;") {
+		t.Errorf("unexpected sheet: %s", data)
+	}
+}
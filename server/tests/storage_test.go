@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"../storage"
+)
+
+func testBlobStore(t *testing.T, store storage.BlobStore) {
+	key := "abc/def/abcdef.png"
+	content := []byte("not really a png")
+
+	if _, err := store.Stat(key); err != storage.ErrNotExist {
+		t.Fatalf("Stat of a missing blob: expected ErrNotExist, got %v", err)
+	}
+	if _, err := store.Get(key); err != storage.ErrNotExist {
+		t.Fatalf("Get of a missing blob: expected ErrNotExist, got %v", err)
+	}
+
+	if err := store.Put(key, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	info, err := store.Stat(key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("Stat size: expected %v, got %v", len(content), info.Size)
+	}
+
+	r, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Get content: expected %q, got %q (%v)", content, data, err)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Stat(key); err != storage.ErrNotExist {
+		t.Errorf("Stat after delete: expected ErrNotExist, got %v", err)
+	}
+	if err := store.Delete(key); err != storage.ErrNotExist {
+		t.Errorf("Delete of a missing blob: expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	testBlobStore(t, storage.NewLocal(root))
+}
+
+func TestS3BlobStore(t *testing.T) {
+	server, fake := NewFakeS3Server()
+	defer server.Close()
+
+	store, err := storage.NewS3(storage.S3Options{
+		Endpoint:       server.URL,
+		Region:         "us-east-1",
+		Bucket:         "uploads",
+		Key:            "key",
+		Secret:         "secret",
+		ForcePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBlobStore(t, store)
+
+	if fake.Len() != 0 {
+		t.Errorf("expected an empty bucket, got %v objects", fake.Len())
+	}
+}
@@ -13,12 +13,39 @@ import (
 func InitTestServer(t *testing.T) *httpexpect.Expect {
 	config.Config.DatabaseDriver = "sqlite3"
 	config.Config.DatabaseDSN = "./test.db"
+	config.Config.StorageDriver = "local"
+	config.Config.StoragePath = "./uploads"
+	config.Config.MailDriver = "file"
+	config.Config.MailPath = "./mail"
+	config.Config.EmailTemplatePath = "../templates/email"
+	// signs the admin tokens
+	if config.Config.AppKey == "" {
+		config.Config.AppKey = "test app key"
+	}
+	// the first admin is created from the config
+	if config.Config.AdminLogin == "" {
+		config.Config.AdminLogin = "admin"
+		config.Config.AdminPassword = "test admin password"
+	}
 
 	app := app.NewApp()
 
 	return httptest.New(t, app)
 }
 
+// otherReason is the decision reason used by most tests
+var otherReason = map[string]string{"other": "Your application does not meet our requirements."}
+
+// setReasons replaces the decision reasons for a test, defer the returned func to restore them.
+func setReasons(decline map[string]string, question map[string]string) (restore func()) {
+	declineReasons, questionReasons := config.Config.DeclineReasons, config.Config.QuestionReasons
+	config.Config.DeclineReasons, config.Config.QuestionReasons = decline, question
+
+	return func() {
+		config.Config.DeclineReasons, config.Config.QuestionReasons = declineReasons, questionReasons
+	}
+}
+
 func JsonObjectFromString(str string, t *testing.T) interface{} {
 	var dat interface{}
 
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"../totp"
+)
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 seed, the last 6 of 8 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range vectors {
+		code, err := totp.Code(secret, totp.Step(time.Unix(unix, 0)))
+		if err != nil || code != expected {
+			t.Errorf("time %v: %v, expected %v %v", unix, code, expected, err)
+		}
+	}
+}
+
+func TestTotpValidate(t *testing.T) {
+	secret := totp.GenerateSecret()
+	now := time.Now()
+	step := totp.Step(now)
+
+	previous, _ := totp.Code(secret, step-1)
+	if matched, ok := totp.Validate(secret, previous, now, 0); !ok || matched != step-1 {
+		t.Errorf("the code of the previous step is accepted for clock drift")
+	}
+
+	old, _ := totp.Code(secret, step-2)
+	if _, ok := totp.Validate(secret, old, now, 0); ok {
+		t.Errorf("the code of two steps ago must be rejected")
+	}
+
+	current, _ := totp.Code(secret, step)
+	if _, ok := totp.Validate(secret, current, now, step); ok {
+		t.Errorf("a used code must be rejected")
+	}
+	if _, ok := totp.Validate(secret, current[:3]+" "+current[3:], now, 0); !ok {
+		t.Errorf("spaces in a code are ignored")
+	}
+
+	uri := totp.URI("MDL KYC", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/MDL%20KYC:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}
@@ -0,0 +1,528 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dchest/captcha"
+	"github.com/iris-contrib/httpexpect"
+	"github.com/kataras/iris/httptest"
+
+	"../config"
+	"../db"
+	"../email"
+	"../imaging"
+	"../model"
+	"../storage"
+	"../utils"
+)
+
+// createWhitelist stores an application with a passport and a selfie
+func createWhitelist(t *testing.T) (*model.Whitelist, string) {
+	var photos []*model.Photo
+	for _, name := range []string{"passport.png", "selfie.png"} {
+		upload, err := imaging.Read(bytes.NewReader(pngBytes(400, 400)), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		photo := &model.Photo{}
+		if err := photo.StoreFile(upload); err != nil {
+			t.Fatal(err)
+		}
+		photos = append(photos, photo)
+	}
+
+	whitelist := &model.Whitelist{
+		PassportId:  photos[0].Id,
+		SelfieId:    sql.NullInt64{Int64: photos[1].Id, Valid: true},
+		Name:        "John Doe",
+		Email:       utils.RandomString(10) + "@example.com",
+		Phone:       "+1 555 0100",
+		Address:     "1 Main st",
+		Birthday:    "1990-01-01",
+		Country:     "Canada",
+		Citizenship: "Canada",
+	}
+	token, err := whitelist.StoreData(email.ConfirmEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return whitelist, token
+}
+
+func TestAdminWhitelistDetail(t *testing.T) {
+	e := InitTestServer(t)
+	whitelist, _ := createWhitelist(t)
+	url := "/admin/whitelist/" + strconv.FormatInt(whitelist.Id, 10)
+
+	e.GET(url).Expect().Status(httptest.StatusUnauthorized)
+
+	data := e.GET(url).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object()
+
+	data.ValueEqual("Email", whitelist.Email).ValueEqual("Phone", whitelist.Phone).
+		ValueEqual("Address", whitelist.Address).ValueEqual("Citizenship", whitelist.Citizenship)
+	data.Value("Passport").Object().Value("Src").String().NotEmpty()
+	data.Value("Selfie").Object().Value("Url").String().NotEmpty()
+	data.Value("Tokens").Array().Length().Equal(1)
+	data.Value("Tokens").Array().Element(0).Object().NotContainsKey("Token")
+
+	e.GET("/admin/whitelist/999999999").WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusNotFound)
+}
+
+func TestWhitelistStageHistory(t *testing.T) {
+	e := InitTestServer(t)
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+	defer setReasons(otherReason, nil)()
+	e.POST("/admin/whitelist/decline/"+id).WithJSON(map[string]interface{}{"reason": "other"}).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+
+	events := e.GET("/admin/whitelist/history/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Array()
+	events.Length().Equal(2)
+	events.Element(0).Object().ValueEqual("FromStage", model.STAGE_EMAIL_NOT_CONFIRMED).
+		ValueEqual("ToStage", model.STAGE_EMAIL_CONFIRMED).ValueEqual("Actor", model.ACTOR_APPLICANT)
+	events.Element(1).Object().ValueEqual("FromStage", model.STAGE_EMAIL_CONFIRMED).
+		ValueEqual("ToStage", model.STAGE_DECLINED).ValueEqual("Actor", config.Config.AdminLogin).
+		ValueEqual("Reason", "other")
+}
+
+func TestWhitelistStageTransitions(t *testing.T) {
+	if _, err := model.FindTransition(model.STAGE_EMAIL_NOT_CONFIRMED, model.STAGE_ACCEPTED); err == nil {
+		t.Errorf("an unconfirmed application must not be accepted")
+	}
+	if _, err := model.FindTransition(model.STAGE_ACCEPTED, model.STAGE_DECLINED); err == nil {
+		t.Errorf("an accepted application must not be declined")
+	}
+	if _, err := model.FindTransition(model.STAGE_EMAIL_CONFIRMED, model.STAGE_QUESTION); err != nil {
+		t.Errorf("a confirmed application can be questioned: %v", err)
+	}
+
+	e := InitTestServer(t)
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+
+	e.POST("/admin/whitelist/accept/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusConflict).JSON().Object().Value("errors").Object().Value("stage").String().
+		Equal("An application can't be moved from unconfirmed to accepted")
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+
+	e.POST("/admin/whitelist/accept/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+	e.POST("/admin/whitelist/decline/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusConflict)
+}
+
+func TestWhitelistDecisionReason(t *testing.T) {
+	e := InitTestServer(t)
+	defer setReasons(nil, map[string]string{"other": "We need more information."})()
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+
+	e.POST("/admin/whitelist/question/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusUnprocessableEntity)
+	e.POST("/admin/whitelist/question/"+id).WithJSON(map[string]interface{}{"reason": "unknown"}).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusUnprocessableEntity).JSON().Object().Value("errors").Object().ContainsKey("reason")
+
+	e.POST("/admin/whitelist/question/"+id).
+		WithJSON(map[string]interface{}{"reason": "other", "note": "Passport photo is blurry", "shareWithApplicant": true}).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+
+	notes := e.GET("/admin/whitelist/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("Notes").Array()
+	notes.Length().Equal(1)
+	notes.Element(0).Object().ValueEqual("ReasonCode", "other").ValueEqual("Note", "Passport photo is blurry")
+}
+
+func TestWhitelistDecisionEmail(t *testing.T) {
+	e := InitTestServer(t)
+	defer setReasons(otherReason, otherReason)()
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+
+	e.POST("/admin/whitelist/question/"+id).WithJSON(map[string]interface{}{"reason": "other", "suppressEmail": true}).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+	e.POST("/admin/whitelist/accept/"+id).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+
+	if _, err := email.Deliver(); err != nil {
+		t.Fatal(err)
+	}
+
+	emails := e.GET("/admin/whitelist/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("Emails").Array()
+	emails.Length().Equal(3)
+	emails.Element(0).Object().ValueEqual("Kind", "confirm_email").ValueEqual("Status", model.EMAIL_SENT).
+		ValueEqual("Text", "").ValueEqual("Html", "")
+	emails.Element(1).Object().ValueEqual("Kind", "question").ValueEqual("Status", model.EMAIL_SUPPRESSED)
+	emails.Element(2).Object().ValueEqual("Kind", "accepted").ValueEqual("Status", model.EMAIL_SENT)
+}
+
+func TestWhitelistQuestionReply(t *testing.T) {
+	e := InitTestServer(t)
+	defer setReasons(nil, map[string]string{"selfie_required": "Please upload a selfie."})()
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+	e.POST("/admin/whitelist/question/"+id).
+		WithJSON(map[string]interface{}{"reason": "selfie_required", "note": "Hold the passport open", "shareWithApplicant": true}).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+
+	// the question email carries the reply token
+	replyToken := &model.WhitelistToken{}
+	has, err := db.Engine.Where("whitelist_id = ? AND purpose = ?", whitelist.Id, model.TOKEN_QUESTION_REPLY).Get(replyToken)
+	if err != nil || !has {
+		t.Fatalf("reply token is not issued: %v", err)
+	}
+
+	e.GET("/whitelist/reply").WithQuery("token", replyToken.Token).Expect().
+		Status(httptest.StatusOK).Body().Contains("Please upload a selfie.").Contains("Hold the passport open")
+
+	e.POST("/whitelist/reply").WithMultipart().WithFormField("token", replyToken.Token).Expect().
+		Status(httptest.StatusUnprocessableEntity)
+
+	e.POST("/whitelist/reply").WithMultipart().
+		WithFormField("token", replyToken.Token).
+		WithFormField("reply", "Here is my selfie").
+		WithFileBytes("selfie", "selfie.png", pngBytes(400, 400)).
+		Expect().Status(httptest.StatusOK)
+
+	// the token works once
+	e.GET("/whitelist/reply").WithQuery("token", replyToken.Token).Expect().
+		Status(httptest.StatusOK).Body().Contains("expired")
+
+	data := e.GET("/admin/whitelist/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object()
+	data.ValueEqual("VerificationStage", model.STAGE_EMAIL_CONFIRMED)
+	data.Value("Selfie").Object().Value("Id").NotEqual(whitelist.SelfieId.Int64)
+	data.Value("Notes").Array().Element(1).Object().ValueEqual("Author", model.ACTOR_APPLICANT).ValueEqual("Note", "Here is my selfie")
+
+	// a question decided by a reviewer can't be answered anymore
+	e.POST("/admin/whitelist/question/"+id).
+		WithJSON(map[string]interface{}{"reason": "selfie_required"}).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+	replyToken = &model.WhitelistToken{}
+	has, err = db.Engine.Where("whitelist_id = ? AND purpose = ? AND used_at IS NULL", whitelist.Id, model.TOKEN_QUESTION_REPLY).Get(replyToken)
+	if err != nil || !has {
+		t.Fatalf("reply token is not issued: %v", err)
+	}
+	e.POST("/admin/whitelist/accept/"+id).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+
+	photos, err := db.Engine.Count(&model.Photo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.POST("/whitelist/reply").WithMultipart().
+		WithFormField("token", replyToken.Token).
+		WithFormField("reply", "Another selfie").
+		WithFileBytes("selfie", "selfie.png", pngBytes(400, 400)).
+		Expect().Status(httptest.StatusOK).Body().Contains("expired")
+	if after, _ := db.Engine.Count(&model.Photo{}); after != photos {
+		t.Errorf("the late reply stored %v photos", after-photos)
+	}
+}
+
+// fixedCaptcha accepts "123456" for every captcha id
+type fixedCaptcha struct{}
+
+func (fixedCaptcha) Set(id string, digits []byte) {}
+
+func (fixedCaptcha) Get(id string, clear bool) []byte {
+	return []byte{1, 2, 3, 4, 5, 6}
+}
+
+// useFixedCaptcha defers to restore the default captcha store
+func useFixedCaptcha() (restore func()) {
+	captcha.SetCustomStore(fixedCaptcha{})
+
+	return func() {
+		captcha.SetCustomStore(captcha.NewMemoryStore(captcha.CollectNum, captcha.Expiration))
+	}
+}
+
+// clearRateLimits forgets requests of earlier runs, the test database is kept between them
+func clearRateLimits(t *testing.T) {
+	if _, err := db.Engine.Where("id > 0").Delete(&model.RateLimitEvent{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWhitelistResendConfirmation(t *testing.T) {
+	e := InitTestServer(t)
+	defer useFixedCaptcha()()
+	clearRateLimits(t)
+	whitelist, token := createWhitelist(t)
+
+	resend := func(address string) *httpexpect.Response {
+		return e.POST("/whitelist/resend_confirmation").
+			WithFormField("email", address).
+			WithFormField("captchaId", "id").
+			WithFormField("captchaSolution", "123456").
+			Expect()
+	}
+
+	e.POST("/whitelist/resend_confirmation").WithFormField("email", whitelist.Email).
+		WithFormField("captchaId", "id").WithFormField("captchaSolution", "000000").
+		Expect().Status(httptest.StatusUnprocessableEntity)
+
+	// the same answer for an unknown email
+	resend(utils.RandomString(10) + "@example.com").Status(httptest.StatusOK).JSON().Object().ValueEqual("success", true)
+	resend(whitelist.Email).Status(httptest.StatusOK).JSON().Object().ValueEqual("success", true)
+
+	// the old link is invalidated
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK).
+		Body().Contains("You can only activate your email once.")
+
+	emails, err := model.GetEmails(whitelist.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 2 || emails[1].Kind != "confirm_email" {
+		t.Fatalf("expected a second confirmation email, got %v", emails)
+	}
+
+	resend(whitelist.Email).Status(httptest.StatusOK)
+	resend(whitelist.Email).Status(httptest.StatusOK)
+	resend(whitelist.Email).Status(httptest.StatusTooManyRequests)
+}
+
+func TestPurgeUnconfirmed(t *testing.T) {
+	InitTestServer(t)
+	abandoned, _ := createWhitelist(t)
+	fresh, _ := createWhitelist(t)
+
+	past := time.Now().Add(-30 * 24 * time.Hour)
+	if _, err := db.Engine.Exec("UPDATE whitelists SET created_at = ? WHERE id = ?", past, abandoned.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Engine.Exec("UPDATE whitelist_tokens SET expired_at = ? WHERE whitelist_id = ?", past, abandoned.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := model.PurgeUnconfirmed(7*24*time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsId(ids, abandoned.Id) || containsId(ids, fresh.Id) {
+		t.Fatalf("dry run: %v", ids)
+	}
+	if _, has, _ := model.GetWhitelistDetail(abandoned.Id); !has {
+		t.Fatal("dry run deleted the application")
+	}
+
+	detail, _, err := model.GetWhitelistDetail(abandoned.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = model.PurgeUnconfirmed(7*24*time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, has, _ := model.GetWhitelistDetail(abandoned.Id); has {
+		t.Error("the application is not deleted")
+	}
+	if _, has, _ := model.GetWhitelistDetail(fresh.Id); !has {
+		t.Error("a fresh application is deleted")
+	}
+	if has, _ := db.Engine.ID(detail.Passport.Id).Exist(&model.Photo{}); has {
+		t.Error("the passport record is not deleted")
+	}
+	if _, err := storage.Store.Stat(detail.Passport.Key()); err != storage.ErrNotExist {
+		t.Errorf("the passport file is not deleted: %v", err)
+	}
+	if tokens, _ := db.Engine.Where("whitelist_id = ?", abandoned.Id).Count(&model.WhitelistToken{}); tokens != 0 {
+		t.Errorf("%d tokens left", tokens)
+	}
+}
+
+func containsId(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestWhitelistErase(t *testing.T) {
+	e := InitTestServer(t)
+	defer setReasons(otherReason, otherReason)()
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+	e.POST("/admin/whitelist/decline/"+id).WithJSON(map[string]interface{}{"reason": "other", "note": "Passport of John Doe expired"}).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+
+	detail, _, err := model.GetWhitelistDetail(whitelist.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bounce quoting the address and a counted request
+	if _, err := db.Engine.Exec("UPDATE whitelist_emails SET error = ? WHERE whitelist_id = ?", "550 <"+whitelist.Email+">: Recipient address rejected", whitelist.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.AllowEvent("resend-email", strings.ToLower(whitelist.Email), 3, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	e.POST("/admin/whitelist/erase/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+	e.POST("/admin/whitelist/erase/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusConflict)
+	e.POST("/admin/whitelist/accept/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusConflict)
+
+	data := e.GET("/admin/whitelist/"+id).WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object()
+	data.ValueEqual("Name", "Erased").ValueEqual("Email", "erased-"+id+"@invalid").ValueEqual("Phone", "").
+		ValueEqual("Country", "Canada").ValueEqual("ErasedBy", config.Config.AdminLogin)
+	data.Value("StageEvents").Array().Length().Equal(2)
+	data.Value("Notes").Array().Element(0).Object().ValueEqual("ReasonCode", "other").ValueEqual("Note", "")
+
+	if _, err := storage.Store.Stat(detail.Passport.Key()); err != storage.ErrNotExist {
+		t.Errorf("the passport file is not deleted: %v", err)
+	}
+
+	emails, err := model.GetEmails(whitelist.Id)
+	if err != nil || len(emails) == 0 || emails[0].Error != "" {
+		t.Errorf("the email log keeps the address: %v %v", emails, err)
+	}
+	if has, _ := db.Engine.Where("subject = ?", strings.ToLower(whitelist.Email)).Exist(&model.RateLimitEvent{}); has {
+		t.Error("the rate limit events keep the address")
+	}
+}
+
+func TestEnforceRetention(t *testing.T) {
+	e := InitTestServer(t)
+	defer setReasons(otherReason, otherReason)()
+	whitelist, token := createWhitelist(t)
+	id := strconv.FormatInt(whitelist.Id, 10)
+
+	e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+	e.POST("/admin/whitelist/decline/"+id).WithJSON(map[string]interface{}{"reason": "other"}).
+		WithBasicAuth(config.Config.AdminLogin, config.Config.AdminPassword).Expect().
+		Status(httptest.StatusOK)
+
+	retention := map[model.VerificationStage]time.Duration{model.STAGE_DECLINED: 24 * time.Hour}
+
+	ids, err := model.EnforceRetention(retention, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsId(ids, whitelist.Id) {
+		t.Fatal("a recent decision is erased")
+	}
+
+	past := time.Now().Add(-48 * time.Hour)
+	if _, err := db.Engine.Exec("UPDATE whitelists SET updated_at = ? WHERE id = ?", past, whitelist.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids, err = model.EnforceRetention(retention, true); err != nil || !containsId(ids, whitelist.Id) {
+		t.Fatalf("dry run: %v %v", ids, err)
+	}
+	if ids, err = model.EnforceRetention(retention, false); err != nil || !containsId(ids, whitelist.Id) {
+		t.Fatalf("%v %v", ids, err)
+	}
+
+	erased, has, err := model.GetWhitelistDetail(whitelist.Id)
+	if err != nil || !has {
+		t.Fatal(err)
+	}
+	if !erased.ErasedAt.Valid || erased.ErasedBy != model.ACTOR_SYSTEM || erased.Name != "Erased" {
+		t.Errorf("not erased: %+v", erased.Whitelist)
+	}
+}
+
+func TestWhitelistReviewQueue(t *testing.T) {
+	e := InitTestServer(t)
+	defer setReasons(otherReason, otherReason)()
+	for i := 0; i < 2; i++ {
+		_, token := createWhitelist(t)
+		e.GET("/whitelist/confirm_email").WithQuery("token", token).Expect().Status(httptest.StatusOK)
+	}
+
+	alice := newTestAdmin(t, model.ROLE_REVIEWER)
+	bob := newTestAdmin(t, model.ROLE_REVIEWER)
+	approver := newTestAdmin(t, model.ROLE_APPROVER)
+	viewer := newTestAdmin(t, model.ROLE_VIEWER)
+
+	// claims are exclusive, the reviewer gets the own claim again
+	first := e.POST("/admin/whitelist/next").WithBasicAuth(alice, alice).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("Id").Number().Raw()
+	e.POST("/admin/whitelist/next").WithBasicAuth(alice, alice).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object().ValueEqual("Id", first)
+	second := e.POST("/admin/whitelist/next").WithBasicAuth(bob, bob).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("data").Object().Value("Id").Number().Raw()
+	if first == second {
+		t.Fatalf("whitelist id: %v is claimed twice", first)
+	}
+	id := strconv.FormatInt(int64(first), 10)
+
+	mine := e.GET("/admin/whitelist/list").WithQuery("assigned", "me").WithBasicAuth(alice, alice).Expect().
+		Status(httptest.StatusOK).JSON().Object()
+	mine.Value("pagination").Object().ValueEqual("rowsNumber", 1)
+	mine.Value("data").Array().Element(0).Object().ValueEqual("Id", first)
+
+	// only the assignee decides and releases
+	decision := map[string]interface{}{"reason": "other", "note": "Please upload a sharper photo", "shareWithApplicant": true}
+	e.POST("/admin/whitelist/question/"+id).WithBasicAuth(bob, bob).WithJSON(decision).Expect().
+		Status(httptest.StatusConflict).JSON().Object().Value("errors").Object().ContainsKey("assignee")
+	e.POST("/admin/whitelist/release/"+id).WithBasicAuth(bob, bob).Expect().Status(httptest.StatusConflict)
+	e.POST("/admin/whitelist/release/"+id).WithBasicAuth(alice, alice).Expect().Status(httptest.StatusOK)
+	e.GET("/admin/whitelist/list").WithQuery("assigned", "me").WithBasicAuth(alice, alice).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("pagination").Object().ValueEqual("rowsNumber", 0)
+
+	// approvers hand applications to reviewers
+	bobAdmin, _, _ := model.GetAdminByLogin(bob)
+	viewerAdmin, _, _ := model.GetAdminByLogin(viewer)
+	e.POST("/admin/whitelist/assign/"+id).WithBasicAuth(bob, bob).WithJSON(map[string]int64{"adminId": bobAdmin.Id}).
+		Expect().Status(httptest.StatusForbidden)
+	e.POST("/admin/whitelist/assign/"+id).WithBasicAuth(approver, approver).WithJSON(map[string]int64{"adminId": viewerAdmin.Id}).
+		Expect().Status(httptest.StatusUnprocessableEntity)
+	e.POST("/admin/whitelist/assign/"+id).WithBasicAuth(approver, approver).WithJSON(map[string]int64{"adminId": bobAdmin.Id}).
+		Expect().Status(httptest.StatusOK)
+	e.GET("/admin/whitelist/list").WithQuery("assigned", "me").WithBasicAuth(bob, bob).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("pagination").Object().ValueEqual("rowsNumber", 2)
+
+	// a decision ends the claim
+	e.POST("/admin/whitelist/question/"+id).WithBasicAuth(bob, bob).WithJSON(decision).Expect().Status(httptest.StatusOK)
+	e.GET("/admin/whitelist/list").WithQuery("assigned", "me").WithBasicAuth(bob, bob).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("pagination").Object().ValueEqual("rowsNumber", 1)
+
+	// expired claims return to the queue
+	if _, err := db.Engine.Exec("UPDATE whitelists SET assigned_until = ? WHERE id = ?", time.Now().Add(-time.Minute), int64(second)); err != nil {
+		t.Fatal(err)
+	}
+	e.POST("/admin/whitelist/question/"+strconv.FormatInt(int64(second), 10)).WithBasicAuth(alice, alice).WithJSON(decision).
+		Expect().Status(httptest.StatusOK)
+}